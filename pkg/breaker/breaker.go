@@ -0,0 +1,269 @@
+// Package breaker provides a circuit breaker for outbound calls, so callers
+// fail fast instead of waiting out full timeouts while a dependency is down.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched by the errors returned instead of calling a
+// dependency while the circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned by Allow and Do while the circuit is open. It
+// matches ErrCircuitOpen with errors.Is.
+type OpenError struct {
+	// Name identifies the breaker.
+	Name string
+	// RetryAfter is how long until a trial call will be allowed; zero while a
+	// trial call is already in flight.
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	if e.Name == "" {
+		return ErrCircuitOpen.Error()
+	}
+	return fmt.Sprintf("circuit breaker %q is open", e.Name)
+}
+
+func (e *OpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// Default settings used when no options are given.
+const (
+	DefaultFailureThreshold = 5
+	DefaultCooldown         = 30 * time.Second
+)
+
+// State is the state of a circuit.
+type State int
+
+const (
+	// Closed lets every call through and counts consecutive failures.
+	Closed State = iota
+	// Open rejects calls with ErrCircuitOpen until the cooldown elapses.
+	Open
+	// HalfOpen lets a trial call through; its outcome closes or reopens
+	// the circuit.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// StateChange describes a transition reported to WithOnStateChange.
+type StateChange struct {
+	Name string
+	From State
+	To   State
+}
+
+// Option configures a Breaker.
+type Option func(*Breaker)
+
+// WithFailureThreshold opens the circuit after n consecutive failures.
+func WithFailureThreshold(n int) Option {
+	return func(b *Breaker) {
+		if n > 0 {
+			b.threshold = n
+		}
+	}
+}
+
+// WithName names the breaker in errors, logs and state-change events.
+func WithName(name string) Option {
+	return func(b *Breaker) {
+		b.name = name
+	}
+}
+
+// WithOnStateChange calls fn after every state transition. fn runs
+// synchronously on the goroutine that caused the transition and must not
+// call back into the breaker.
+func WithOnStateChange(fn func(StateChange)) Option {
+	return func(b *Breaker) {
+		b.onChange = fn
+	}
+}
+
+// WithFailurePredicate decides which errors passed to Record count as
+// failures. Errors it rejects neither open nor close the circuit. By default
+// every error except context.Canceled is a failure.
+func WithFailurePredicate(isFailure func(error) bool) Option {
+	return func(b *Breaker) {
+		if isFailure != nil {
+			b.isFailure = isFailure
+		}
+	}
+}
+
+// WithCooldown keeps the circuit open for d before a trial call is allowed.
+func WithCooldown(d time.Duration) Option {
+	return func(b *Breaker) {
+		if d > 0 {
+			b.cooldown = d
+		}
+	}
+}
+
+// Breaker is a consecutive-failure circuit breaker. It is safe for
+// concurrent use.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	isFailure func(error) bool
+	onChange  func(StateChange)
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// New creates a closed Breaker.
+func New(opts ...Option) *Breaker {
+	b := &Breaker{
+		threshold: DefaultFailureThreshold,
+		cooldown:  DefaultCooldown,
+		isFailure: isFailure,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func isFailure(err error) bool {
+	return !errors.Is(err, context.Canceled)
+}
+
+// Name returns the name set with WithName.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current state. An open circuit whose cooldown has
+// elapsed reports HalfOpen.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	change := b.refresh()
+	state := b.state
+	b.mu.Unlock()
+
+	b.notify(change)
+	return state
+}
+
+// Allow reports whether a call may proceed, returning an *OpenError if not.
+// Every allowed call must be followed by Record or Release.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	change := b.refresh()
+	var err error
+	switch {
+	case b.state == Open:
+		err = &OpenError{Name: b.name, RetryAfter: b.cooldown - b.now().Sub(b.openedAt)}
+	case b.state == HalfOpen && b.trial:
+		err = &OpenError{Name: b.name}
+	case b.state == HalfOpen:
+		b.trial = true
+	}
+	b.mu.Unlock()
+
+	b.notify(change)
+	return err
+}
+
+// Record reports the outcome of an allowed call. A nil err counts as a
+// success.
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	var change *StateChange
+	switch {
+	case err == nil:
+		b.failures = 0
+		change = b.transition(Closed)
+	case !b.isFailure(err):
+		// neither outcome; let another call try
+	default:
+		b.failures++
+		if b.state == HalfOpen || b.failures >= b.threshold {
+			b.openedAt = b.now()
+			change = b.transition(Open)
+		}
+	}
+	b.trial = false
+	b.mu.Unlock()
+
+	b.notify(change)
+}
+
+// Release ends an allowed call without an outcome, as when the caller gave
+// up before the dependency answered. A half-open circuit lets another call
+// try.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Do calls fn if the circuit allows it and records its outcome.
+func (b *Breaker) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	err := fn(ctx)
+	b.Record(err)
+	return err
+}
+
+// refresh moves an open circuit to half-open once its cooldown has elapsed.
+// The caller must hold b.mu.
+func (b *Breaker) refresh() *StateChange {
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.cooldown {
+		return b.transition(HalfOpen)
+	}
+	return nil
+}
+
+// transition sets the state, returning the change to report if it differs.
+// The caller must hold b.mu.
+func (b *Breaker) transition(to State) *StateChange {
+	if b.state == to {
+		return nil
+	}
+	change := &StateChange{Name: b.name, From: b.state, To: to}
+	b.state = to
+	return change
+}
+
+func (b *Breaker) notify(change *StateChange) {
+	if change == nil {
+		return
+	}
+	slog.Info("circuit breaker state changed", "name", change.Name, "from", change.From.String(), "to", change.To.String())
+	if b.onChange != nil {
+		b.onChange(*change)
+	}
+}
@@ -0,0 +1,173 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBoom = errors.New("boom")
+
+func newTestBreaker(now *time.Time, opts ...Option) *Breaker {
+	b := New(opts...)
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+func fail(context.Context) error { return errBoom }
+
+func succeed(context.Context) error { return nil }
+
+func TestShouldOpenAfterConsecutiveFailures(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	b := newTestBreaker(&now, WithFailureThreshold(2))
+	ctx := context.Background()
+
+	// Act
+	require.ErrorIs(t, b.Do(ctx, fail), errBoom)
+	require.ErrorIs(t, b.Do(ctx, fail), errBoom)
+	err := b.Do(ctx, succeed)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
+
+func TestShouldResetFailuresOnSuccess(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	b := newTestBreaker(&now, WithFailureThreshold(2))
+	ctx := context.Background()
+
+	// Act
+	_ = b.Do(ctx, fail)
+	require.NoError(t, b.Do(ctx, succeed))
+	_ = b.Do(ctx, fail)
+	err := b.Do(ctx, succeed)
+
+	// Assert
+	assert.NoError(t, err)
+}
+
+func TestShouldAllowSingleTrialAfterCooldown(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	b := newTestBreaker(&now, WithFailureThreshold(1), WithCooldown(time.Second))
+	_ = b.Do(context.Background(), fail)
+	now = now.Add(time.Second)
+
+	// Act
+	first := b.Allow()
+	second := b.Allow()
+
+	// Assert
+	assert.NoError(t, first)
+	assert.ErrorIs(t, second, ErrCircuitOpen)
+}
+
+func TestShouldAllowAnotherTrialAfterRelease(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	b := newTestBreaker(&now, WithFailureThreshold(1), WithCooldown(time.Second))
+	_ = b.Do(context.Background(), fail)
+	now = now.Add(time.Second)
+	require.NoError(t, b.Allow())
+
+	// Act
+	b.Release()
+	err := b.Allow()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, HalfOpen, b.State())
+}
+
+func TestShouldCloseOrReopenAfterTrial(t *testing.T) {
+	tests := []struct {
+		name   string
+		trial  func(context.Context) error
+		closed bool
+	}{
+		{name: "trial succeeds", trial: succeed, closed: true},
+		{name: "trial fails", trial: fail, closed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			now := time.Now()
+			b := newTestBreaker(&now, WithFailureThreshold(3), WithCooldown(time.Second))
+			for range 3 {
+				_ = b.Do(context.Background(), fail)
+			}
+			now = now.Add(time.Second)
+
+			// Act
+			_ = b.Do(context.Background(), tt.trial)
+			err := b.Allow()
+
+			// Assert
+			if tt.closed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrCircuitOpen)
+			}
+		})
+	}
+}
+
+func TestShouldReportStateChanges(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	var changes []StateChange
+	b := newTestBreaker(&now, WithName("leases"), WithFailureThreshold(1), WithCooldown(time.Second),
+		WithOnStateChange(func(c StateChange) { changes = append(changes, c) }))
+
+	// Act
+	_ = b.Do(context.Background(), fail)
+	now = now.Add(time.Second)
+	_ = b.Do(context.Background(), succeed)
+
+	// Assert
+	assert.Equal(t, []StateChange{
+		{Name: "leases", From: Closed, To: Open},
+		{Name: "leases", From: Open, To: HalfOpen},
+		{Name: "leases", From: HalfOpen, To: Closed},
+	}, changes)
+}
+
+func TestShouldReturnOpenErrorWithRetryAfter(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	b := newTestBreaker(&now, WithName("auth"), WithFailureThreshold(1), WithCooldown(time.Minute))
+	_ = b.Do(context.Background(), fail)
+	now = now.Add(20 * time.Second)
+
+	// Act
+	err := b.Allow()
+
+	// Assert
+	var open *OpenError
+	require.ErrorAs(t, err, &open)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, "auth", open.Name)
+	assert.Equal(t, 40*time.Second, open.RetryAfter)
+	assert.Equal(t, Open, b.State())
+}
+
+func TestShouldIgnoreErrorsRejectedByFailurePredicate(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	b := newTestBreaker(&now, WithFailureThreshold(1))
+
+	// Act
+	err := b.Do(context.Background(), func(context.Context) error { return context.Canceled })
+
+	// Assert
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, Closed, b.State())
+}
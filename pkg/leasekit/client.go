@@ -7,8 +7,13 @@ import (
 
 	"github.com/fgrzl/messaging"
 	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/breaker"
+	"github.com/hydn-co/mesh-sdk/pkg/requestkit"
 )
 
+// requestTimeout bounds each request to the lease Manager.
+const requestTimeout = 5 * time.Second
+
 var (
 	// Empty is a zero-value Lease used for comparison and tests.
 	Empty Lease
@@ -30,14 +35,31 @@ type Client interface {
 	Release(ctx context.Context, lease *Lease) error
 }
 
+// ClientOptions configure a Client created by NewLeaseClient.
+type ClientOptions func(*client)
+
+// WithBreaker fails lease requests fast with breaker.ErrCircuitOpen while b
+// is open. Transport failures and timeouts count against b; error replies
+// from the Manager do not.
+func WithBreaker(b *breaker.Breaker) ClientOptions {
+	return func(l *client) {
+		l.breaker = b
+	}
+}
+
 // NewLeaseClient creates a new Client that communicates with the lease
 // Manager using the provided messaging.MessageBusFactory.
-func NewLeaseClient(busFactory messaging.MessageBusFactory) Client {
-	return &client{busFactory: busFactory}
+func NewLeaseClient(busFactory messaging.MessageBusFactory, opts ...ClientOptions) Client {
+	l := &client{busFactory: busFactory}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
 }
 
 type client struct {
 	busFactory messaging.MessageBusFactory
+	breaker    *breaker.Breaker
 }
 
 func (l *client) Acquire(
@@ -64,7 +86,7 @@ func (l *client) Acquire(
 		MaxAttempts: maxAttempts,
 	}
 
-	return messaging.SendRequestWithContext[*Acquire, *Lease](ctx, bus, req, 5*time.Second)
+	return send[*Lease](ctx, l.breaker, bus, req)
 }
 
 func (l *client) Renew(ctx context.Context, lease *Lease) error {
@@ -90,7 +112,8 @@ func (l *client) Renew(ctx context.Context, lease *Lease) error {
 		TTL:      lease.TTL,
 	}
 
-	if _, err := messaging.SendRequestWithContext[*Renew, *messaging.Accepted](ctx, bus, req, 5*time.Second); err != nil {
+	_, err = send[*messaging.Accepted](ctx, l.breaker, bus, req)
+	if err != nil {
 		return err
 	}
 
@@ -114,6 +137,12 @@ func (l *client) Release(ctx context.Context, lease *Lease) error {
 		Key:      lease.Key,
 	}
 
-	_, err = messaging.SendRequestWithContext[*Release, *messaging.Accepted](ctx, bus, req, 5*time.Second)
+	_, err = send[*messaging.Accepted](ctx, l.breaker, bus, req)
 	return err
 }
+
+// send issues req and returns its TResp reply. Error replies from the
+// Manager are returned as *requestkit.RemoteError.
+func send[TResp messaging.Response](ctx context.Context, b *breaker.Breaker, bus messaging.MessageBus, req messaging.Request) (TResp, error) {
+	return requestkit.Send[TResp](ctx, bus, req, requestkit.Options{Timeout: requestTimeout, Breaker: b})
+}
@@ -2,14 +2,18 @@ package leasekit
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/fgrzl/messaging"
 	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/breaker"
+	"github.com/hydn-co/mesh-sdk/pkg/requestkit"
 	"github.com/hydn-co/mesh-sdk/pkg/testkit"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLeaseClient_Acquire(t *testing.T) {
@@ -86,3 +90,42 @@ func TestLeaseClient_Release(t *testing.T) {
 	// Assert
 	assert.NoError(t, err)
 }
+
+func TestLeaseClient_FailsFastWhileBreakerIsOpen(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	bus.Mock.On("RequestWithContext", mock.Anything, mock.Anything, mock.Anything).
+		Return(&messaging.Accepted{}, errors.New("nats: no responders available for request")).Once()
+
+	b := breaker.New(breaker.WithFailureThreshold(1), breaker.WithCooldown(time.Hour))
+	client := NewLeaseClient(testkit.ConfigureBusFactory(bus), WithBreaker(b))
+	lease := &Lease{ID: uuid.New(), Key: "test-key"}
+	assert.Error(t, client.Release(context.Background(), lease))
+
+	// Act
+	_, err := client.Acquire(context.Background(), uuid.New(), "test-key", time.Second, 1)
+
+	// Assert
+	assert.ErrorIs(t, err, breaker.ErrCircuitOpen)
+	bus.AssertNumberOfCalls(t, "RequestWithContext", 1)
+}
+
+func TestLeaseClient_ReturnsManagerErrors(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	bus.Mock.On("RequestWithContext", mock.Anything, mock.Anything, mock.Anything).
+		Return(&messaging.ErrorResponse{Error: "no lease for key: test-key"}, nil).Once()
+
+	b := breaker.New(breaker.WithFailureThreshold(1))
+	client := NewLeaseClient(testkit.ConfigureBusFactory(bus), WithBreaker(b))
+	lease := &Lease{ID: uuid.New(), Key: "test-key", TTL: time.Second, ExpireAt: time.Now().Add(time.Minute)}
+
+	// Act
+	err := client.Renew(context.Background(), lease)
+
+	// Assert
+	var remote *requestkit.RemoteError
+	require.ErrorAs(t, err, &remote)
+	assert.Equal(t, "no lease for key: test-key", remote.Message)
+	assert.Equal(t, breaker.Closed, b.State())
+}
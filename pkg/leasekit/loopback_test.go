@@ -0,0 +1,59 @@
+package leasekit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/messaging/loopback"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startLoopbackManager(t *testing.T) Client {
+	t.Helper()
+	bus := loopback.New()
+	t.Cleanup(func() { _ = bus.Close() })
+	factory := loopback.NewFactory(bus)
+	require.NoError(t, NewManager(factory).Start(context.Background()))
+	return NewLeaseClient(factory)
+}
+
+func TestLeaseClient_AcquireRenewReleaseThroughManager(t *testing.T) {
+	// Arrange
+	client := startLoopbackManager(t)
+	ctx := context.Background()
+	tenantID := uuid.New()
+
+	// Act
+	lease, err := client.Acquire(ctx, tenantID, "key", time.Minute, 1)
+	require.NoError(t, err)
+	renewErr := client.Renew(ctx, lease)
+	_, heldErr := client.Acquire(ctx, tenantID, "key", time.Minute, 1)
+	releaseErr := client.Release(ctx, lease)
+	reacquired, reacquireErr := client.Acquire(ctx, tenantID, "key", time.Minute, 1)
+
+	// Assert
+	assert.Equal(t, tenantID, lease.TenantID)
+	assert.NoError(t, renewErr)
+	assert.Error(t, heldErr)
+	assert.NoError(t, releaseErr)
+	require.NoError(t, reacquireErr)
+	assert.NotEqual(t, lease.ID, reacquired.ID)
+}
+
+func TestLeaseClient_IsolatesTenantsThroughManager(t *testing.T) {
+	// Arrange
+	client := startLoopbackManager(t)
+	ctx := context.Background()
+	_, err := client.Acquire(ctx, uuid.New(), "key", time.Minute, 1)
+	require.NoError(t, err)
+
+	// Act
+	lease, err := client.Acquire(ctx, uuid.New(), "key", time.Minute, 1)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "key", lease.Key)
+}
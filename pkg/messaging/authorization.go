@@ -0,0 +1,181 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/fgrzl/claims"
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/requestkit"
+)
+
+// ErrForbidden is wrapped by authorization failures, including the errors
+// request clients return for requests whose handler denied the caller.
+var ErrForbidden = requestkit.ErrForbidden
+
+// Authorizer decides whether principal may invoke a handler with msg. A
+// non-nil error rejects the invocation and becomes the denial reason.
+type Authorizer func(ctx context.Context, principal claims.Principal, msg polymorphic.Polymorphic) error
+
+// HandlerOptions configure a single handler registration.
+type HandlerOptions func(*handlerOptions)
+
+type handlerOptions struct {
+	middleware []Middleware
+}
+
+// WithAuthorization rejects invocations whose principal fails any of rules.
+// Rejected invocations fail with an error wrapping ErrForbidden, which request
+// clients return as such. Every denial is logged at Warn.
+func WithAuthorization(rules ...Authorizer) HandlerOptions {
+	return func(o *handlerOptions) {
+		o.middleware = append(o.middleware, AuthorizationMiddleware(allOf(rules)))
+	}
+}
+
+// WithHandlerMiddleware adds middleware to a single handler. It runs inside
+// the processor-level pipeline configured with Use or WithMiddleware.
+func WithHandlerMiddleware(mws ...Middleware) HandlerOptions {
+	return func(o *handlerOptions) {
+		o.middleware = append(o.middleware, mws...)
+	}
+}
+
+func buildHandlerOptions(opts []HandlerOptions) *handlerOptions {
+	o := &handlerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// RequireRoles allows principals holding every one of roles.
+func RequireRoles(roles ...string) Authorizer {
+	return func(ctx context.Context, principal claims.Principal, msg polymorphic.Polymorphic) error {
+		held := principal.Roles()
+		for _, role := range roles {
+			if !slices.Contains(held, role) {
+				return fmt.Errorf("%w: missing role %q", ErrForbidden, role)
+			}
+		}
+		return nil
+	}
+}
+
+// RequireAnyRole allows principals holding at least one of roles.
+func RequireAnyRole(roles ...string) Authorizer {
+	return func(ctx context.Context, principal claims.Principal, msg polymorphic.Polymorphic) error {
+		held := principal.Roles()
+		for _, role := range roles {
+			if slices.Contains(held, role) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: requires one of roles %v", ErrForbidden, roles)
+	}
+}
+
+// RequireScopes allows principals granted every one of scopes.
+func RequireScopes(scopes ...string) Authorizer {
+	return func(ctx context.Context, principal claims.Principal, msg polymorphic.Polymorphic) error {
+		granted := principal.Scopes()
+		for _, scope := range scopes {
+			if !slices.Contains(granted, scope) {
+				return fmt.Errorf("%w: missing scope %q", ErrForbidden, scope)
+			}
+		}
+		return nil
+	}
+}
+
+// RequireTenantMatch allows principals whose audience includes the tenant
+// the request is addressed to. The audience travels with the principal over
+// the bus, unlike custom claims, so the rule holds on the receiving side. See
+// RequireTenantMatchWith for how the request tenant is resolved.
+func RequireTenantMatch() Authorizer {
+	return tenantMatch(func(principal claims.Principal, want uuid.UUID) (matched, hasTenant bool) {
+		for _, aud := range principal.Audience() {
+			id, err := uuid.Parse(aud)
+			if err != nil || id == uuid.Nil {
+				continue
+			}
+			if id == want {
+				return true, true
+			}
+			hasTenant = true
+		}
+		return false, hasTenant
+	})
+}
+
+// RequireTenantMatchWith is RequireTenantMatch with a custom lookup of the
+// principal's tenant. The request tenant is read from a GetTenantID method when
+// the message has one, otherwise from the ID of a tenant-scoped route. Messages
+// with no resolvable tenant are rejected. The lookup may only rely on fields
+// the bus serializes with the principal; custom claims are dropped in transit.
+func RequireTenantMatchWith(principalTenant func(claims.Principal) (uuid.UUID, bool)) Authorizer {
+	return tenantMatch(func(principal claims.Principal, want uuid.UUID) (matched, hasTenant bool) {
+		have, ok := principalTenant(principal)
+		if !ok || have == uuid.Nil {
+			return false, false
+		}
+		return have == want, true
+	})
+}
+
+// tenantMatch rejects messages with no resolvable tenant and principals match
+// does not place in it.
+func tenantMatch(match func(principal claims.Principal, want uuid.UUID) (matched, hasTenant bool)) Authorizer {
+	return func(ctx context.Context, principal claims.Principal, msg polymorphic.Polymorphic) error {
+		want, ok := requestTenant(msg)
+		if !ok {
+			return fmt.Errorf("%w: request is not tenant scoped", ErrForbidden)
+		}
+		matched, hasTenant := match(principal, want)
+		switch {
+		case matched:
+			return nil
+		case !hasTenant:
+			return fmt.Errorf("%w: principal has no tenant", ErrForbidden)
+		default:
+			return fmt.Errorf("%w: tenant mismatch", ErrForbidden)
+		}
+	}
+}
+
+// allOf combines rules so every rule must pass.
+func allOf(rules []Authorizer) Authorizer {
+	return func(ctx context.Context, principal claims.Principal, msg polymorphic.Polymorphic) error {
+		for _, rule := range rules {
+			if rule == nil {
+				continue
+			}
+			if err := rule(ctx, principal, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// requestTenant resolves the tenant a message is addressed to, ignoring any
+// tenant carried by the caller's context.
+func requestTenant(msg polymorphic.Polymorphic) (uuid.UUID, bool) {
+	type tenantScoped interface{ GetTenantID() uuid.UUID }
+	if t, ok := msg.(tenantScoped); ok {
+		if id := t.GetTenantID(); id != uuid.Nil {
+			return id, true
+		}
+	}
+	type routed interface{ GetRoute() messaging.Route }
+	if r, ok := msg.(routed); ok {
+		route := r.GetRoute()
+		if route.Scope == messaging.ScopeTenant && route.ID != nil && *route.ID != uuid.Nil {
+			return *route.ID, true
+		}
+	}
+	return uuid.Nil, false
+}
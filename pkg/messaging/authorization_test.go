@@ -0,0 +1,168 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fgrzl/claims"
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/messaging/loopback"
+	"github.com/hydn-co/mesh-sdk/pkg/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPrincipal(tenantID uuid.UUID, roles, scopes []string) claims.Principal {
+	set := claims.NewClaimsSet(uuid.NewString()).
+		SetRoles(roles...).
+		SetScopes(scopes...).
+		SetAudience(tenantID.String())
+	return claims.NewPrincipal(set)
+}
+
+func TestShouldEvaluateAuthorizationRules(t *testing.T) {
+	tenantID := uuid.New()
+	tests := []struct {
+		name    string
+		rule    Authorizer
+		req     *testRequest
+		allowed bool
+	}{
+		{name: "required role held", rule: RequireRoles("admin"), req: &testRequest{TenantID: tenantID}, allowed: true},
+		{name: "required role missing", rule: RequireRoles("admin", "owner"), req: &testRequest{TenantID: tenantID}, allowed: false},
+		{name: "any role held", rule: RequireAnyRole("owner", "admin"), req: &testRequest{TenantID: tenantID}, allowed: true},
+		{name: "any role missing", rule: RequireAnyRole("owner"), req: &testRequest{TenantID: tenantID}, allowed: false},
+		{name: "scope granted", rule: RequireScopes("leases"), req: &testRequest{TenantID: tenantID}, allowed: true},
+		{name: "scope missing", rule: RequireScopes("streamkit"), req: &testRequest{TenantID: tenantID}, allowed: false},
+		{name: "tenant matches", rule: RequireTenantMatch(), req: &testRequest{TenantID: tenantID}, allowed: true},
+		{name: "tenant differs", rule: RequireTenantMatch(), req: &testRequest{TenantID: uuid.New()}, allowed: false},
+		{name: "tenant missing on request", rule: RequireTenantMatch(), req: &testRequest{}, allowed: false},
+		{name: "tenant by custom lookup", rule: RequireTenantMatchWith(func(claims.Principal) (uuid.UUID, bool) { return tenantID, true }), req: &testRequest{TenantID: tenantID}, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			principal := newTestPrincipal(tenantID, []string{"admin"}, []string{"leases"})
+
+			// Act
+			err := tt.rule(context.Background(), principal, tt.req)
+
+			// Assert
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrForbidden)
+			}
+		})
+	}
+}
+
+func TestShouldMatchTenantOfPrincipalReceivedOverBus(t *testing.T) {
+	// Arrange
+	tenantID := uuid.New()
+	serialized, err := claims.SerializePrincipal(newTestPrincipal(tenantID, nil, nil))
+	require.NoError(t, err)
+	received, err := claims.DeserializePrincipal(serialized)
+	require.NoError(t, err)
+
+	// Act
+	matchErr := RequireTenantMatch()(context.Background(), received, &testRequest{TenantID: tenantID})
+	mismatchErr := RequireTenantMatch()(context.Background(), received, &testRequest{TenantID: uuid.New()})
+
+	// Assert
+	assert.NoError(t, matchErr)
+	assert.ErrorIs(t, mismatchErr, ErrForbidden)
+}
+
+func TestShouldMatchTenantForPrincipalSentOverLoopbackBus(t *testing.T) {
+	// Arrange
+	bus := loopback.New()
+	defer bus.Close()
+	p := NewMessageBusProcessorBase(bus)
+	tenantID := uuid.New()
+	_, err := RegisterRequestHandler(p, messaging.NewTenantRoute("test", "request", nil), func(ctx context.Context, r *testRequest) (*messaging.Accepted, error) {
+		return &messaging.Accepted{}, nil
+	}, WithAuthorization(RequireTenantMatch()))
+	require.NoError(t, err)
+	client := NewRequestClient[*testRequest, *messaging.Accepted](loopback.NewFactory(bus))
+	ctx := claims.WithUser(context.Background(), newTestPrincipal(tenantID, nil, nil))
+
+	// Act
+	resp, err := client.Send(ctx, &testRequest{TenantID: tenantID})
+
+	// Assert
+	require.NoError(t, err)
+	assert.NotNil(t, resp)
+}
+
+func TestShouldReturnForbiddenToClientOverLoopbackBus(t *testing.T) {
+	// Arrange
+	bus := loopback.New()
+	defer bus.Close()
+	p := NewMessageBusProcessorBase(bus)
+	_, err := RegisterRequestHandler(p, messaging.NewTenantRoute("test", "request", nil), func(ctx context.Context, r *testRequest) (*messaging.Accepted, error) {
+		return &messaging.Accepted{}, nil
+	}, WithAuthorization(RequireTenantMatch()))
+	require.NoError(t, err)
+	client := NewRequestClient[*testRequest, *messaging.Accepted](loopback.NewFactory(bus))
+	ctx := claims.WithUser(context.Background(), newTestPrincipal(uuid.New(), nil, nil))
+
+	// Act
+	_, err = client.Send(ctx, &testRequest{TenantID: uuid.New()})
+
+	// Assert
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.ErrorContains(t, err, "tenant mismatch")
+}
+
+func TestShouldFailForbiddenMessageWithError(t *testing.T) {
+	// Arrange
+	route := messaging.NewGlobalRoute("test", "message")
+	handler := wrapMessageHandler(route, func(ctx context.Context, msg messaging.Message) error {
+		return nil
+	}, []Middleware{AuthorizationMiddleware(RequireRoles("admin"))})
+	ctx := claims.WithUser(context.Background(), newTestPrincipal(uuid.New(), nil, nil))
+
+	// Act
+	err := handler(ctx, &testMessage{})
+
+	// Assert
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestShouldApplyAuthorizationOptionWhenRegisteringTypedHandler(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	var registered messaging.RequestHandler
+	bus.Mock.On("SubscribeRequest", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { registered = args.Get(1).(messaging.RequestHandler) }).
+		Return(&mockSubscription{id: uuid.New()}, nil).Once()
+	p := NewMessageBusProcessorBase(bus)
+	req := &testRequest{TenantID: uuid.New()}
+	called := false
+
+	_, err := RegisterRequestHandler(p, req.GetRoute(), func(ctx context.Context, r *testRequest) (*messaging.Accepted, error) {
+		called = true
+		return &messaging.Accepted{}, nil
+	}, WithAuthorization(RequireTenantMatch()))
+	require.NoError(t, err)
+	ctx := claims.WithUser(context.Background(), newTestPrincipal(uuid.New(), nil, nil))
+
+	// Act
+	resp, err := registered(ctx, req)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.Nil(t, resp)
+	assert.False(t, called)
+}
+
+// testMessage implements messaging.Message for authorization tests.
+type testMessage struct{}
+
+func (*testMessage) GetDiscriminator() string { return "mesh://test/message" }
+
+func (*testMessage) GetRoute() messaging.Route { return messaging.NewGlobalRoute("test", "message") }
@@ -2,18 +2,26 @@ package messaging
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 
 	"github.com/fgrzl/claims"
+	"github.com/fgrzl/json/polymorphic"
 	"github.com/fgrzl/messaging"
 	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // RegisterMessageHandler is a generic helper for registering typed message handlers.
+// Options such as WithAuthorization apply to this handler only.
 func RegisterMessageHandler[TMessage messaging.Message](
 	p MessageBusProcessor,
 	route messaging.Route,
 	handler func(context.Context, TMessage) error,
+	opts ...HandlerOptions,
 ) (messaging.Subscription, error) {
 	wrapped := func(ctx context.Context, msg messaging.Message) error {
 		cast, ok := msg.(TMessage)
@@ -22,14 +30,17 @@ func RegisterMessageHandler[TMessage messaging.Message](
 		}
 		return handler(ctx, cast)
 	}
-	return p.RegisterMessageHandler(route, wrapped)
+	o := buildHandlerOptions(opts)
+	return p.RegisterMessageHandler(route, wrapMessageHandler(route, wrapped, o.middleware))
 }
 
 // RegisterRequestHandler is a generic helper to safely cast and register typed request handlers.
+// Options such as WithAuthorization apply to this handler only.
 func RegisterRequestHandler[TRequest messaging.Request, TResponse messaging.Response](
 	processor MessageBusProcessor,
 	route messaging.Route,
 	handler func(context.Context, TRequest) (TResponse, error),
+	opts ...HandlerOptions,
 ) (messaging.Subscription, error) {
 	wrapped := func(ctx context.Context, req messaging.Request) (messaging.Response, error) {
 		castReq, ok := req.(TRequest)
@@ -42,7 +53,8 @@ func RegisterRequestHandler[TRequest messaging.Request, TResponse messaging.Resp
 		}
 		return resp, nil
 	}
-	return processor.RegisterRequestHandler(route, wrapped)
+	o := buildHandlerOptions(opts)
+	return processor.RegisterRequestHandler(route, wrapRequestHandler(route, wrapped, o.middleware))
 }
 
 // MessageBusProcessor defines a component capable of handling typed request-response interactions.
@@ -54,34 +66,89 @@ type MessageBusProcessor interface {
 	RegisterRequestHandler(route messaging.Route, handler messaging.RequestHandler) (messaging.Subscription, error)
 }
 
+// MessageBusProcessorOptions configure a MessageBusProcessorBase at construction.
+type MessageBusProcessorOptions func(*MessageBusProcessorBase)
+
+// WithMiddleware appends middleware applied to every handler registered on
+// the processor. The first middleware is the outermost layer.
+func WithMiddleware(mws ...Middleware) MessageBusProcessorOptions {
+	return func(p *MessageBusProcessorBase) {
+		p.middleware = append(p.middleware, mws...)
+	}
+}
+
 type MessageBusProcessorBase struct {
 	SubscriptionTracker
-	bus     messaging.MessageBus
-	monitor *RequestHandlerMonitor
+	bus messaging.MessageBus
+
+	monitorMu   sync.Mutex
+	monitor     *RequestHandlerMonitor
+	unmonitored map[uuid.UUID]*requestSubscription
+
+	mwMu       sync.RWMutex
+	middleware []Middleware
+
+	inflight inflightTracker
 }
 
-func NewMessageBusProcessorBase(bus messaging.MessageBus) *MessageBusProcessorBase {
-	return &MessageBusProcessorBase{
-		SubscriptionTracker: NewSubscriptionTracker(),
+func NewMessageBusProcessorBase(bus messaging.MessageBus, opts ...MessageBusProcessorOptions) *MessageBusProcessorBase {
+	p := &MessageBusProcessorBase{
+		SubscriptionTracker: NewRouteTracker(),
 		bus:                 bus,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Use appends middleware to the processor's pipeline. Middleware only applies
+// to handlers registered after the call; existing subscriptions keep the
+// pipeline they were registered with.
+func (p *MessageBusProcessorBase) Use(mws ...Middleware) {
+	p.mwMu.Lock()
+	defer p.mwMu.Unlock()
+	p.middleware = append(p.middleware, mws...)
+}
+
+func (p *MessageBusProcessorBase) snapshotMiddleware() []Middleware {
+	p.mwMu.RLock()
+	defer p.mwMu.RUnlock()
+	return append([]Middleware(nil), p.middleware...)
 }
 
 func (p *MessageBusProcessorBase) RegisterMessageHandler(
 	route messaging.Route,
 	handler messaging.MessageHandler,
 ) (messaging.Subscription, error) {
+	handler = p.trackMessageHandler(route, wrapMessageHandler(route, handler, p.snapshotMiddleware()))
 	sub, err := p.bus.Subscribe(route, handler)
 	if err != nil {
 		return nil, err
 	}
-	p.Track(sub)
+	p.trackRoute(route, sub)
 	return sub, nil
 }
 
-func (p *MessageBusProcessorBase) EnableRequestMonitoring(ctx context.Context) {
-	if p.monitor == nil {
-		p.monitor = NewRequestHandlerMonitor(ctx, p.bus)
+// EnableRequestMonitoring routes request handlers through a
+// RequestHandlerMonitor so they survive connection loss. Handlers registered
+// before the call move into the monitor; the subscriptions returned for them
+// stay valid. Shutdown stops the monitor, and a later call starts a new one.
+func (p *MessageBusProcessorBase) EnableRequestMonitoring(ctx context.Context, opts ...RequestHandlerMonitorOptions) {
+	p.monitorMu.Lock()
+	defer p.monitorMu.Unlock()
+	if p.monitor != nil {
+		return
+	}
+	p.monitor = NewRequestHandlerMonitor(ctx, p.bus, opts...)
+	for id, rs := range p.unmonitored {
+		if err := rs.moveTo(p.monitor); err != nil {
+			slog.ErrorContext(ctx, "failed to monitor request handler; it stays subscribed unmonitored",
+				"route", rs.route.String(),
+				"error", err)
+			continue
+		}
+		delete(p.unmonitored, id)
 	}
 }
 
@@ -89,23 +156,44 @@ func (p *MessageBusProcessorBase) RegisterRequestHandler(
 	route messaging.Route,
 	handler messaging.RequestHandler,
 ) (messaging.Subscription, error) {
+	handler = p.trackRequestHandler(route, wrapRequestHandler(route, handler, p.snapshotMiddleware()))
+
+	p.monitorMu.Lock()
+	defer p.monitorMu.Unlock()
+
 	// If monitoring is enabled, use the monitor
 	if p.monitor != nil {
 		sub, err := p.monitor.Register(route, handler)
 		if err != nil {
 			return nil, err
 		}
-		p.Track(sub)
+		p.trackRoute(route, sub)
 		return sub, nil
 	}
 
-	// Otherwise use regular subscription
+	// Otherwise subscribe directly, keeping the handler so monitoring can
+	// adopt it when enabled
 	sub, err := p.bus.SubscribeRequest(route, handler)
 	if err != nil {
 		return nil, err
 	}
+	rs := &requestSubscription{id: uuid.New(), route: route, handler: handler, sub: sub, p: p}
+	if p.unmonitored == nil {
+		p.unmonitored = make(map[uuid.UUID]*requestSubscription)
+	}
+	p.unmonitored[rs.id] = rs
+	p.trackRoute(route, rs)
+	return rs, nil
+}
+
+// trackRoute records sub with its route when the processor's tracker is a
+// RouteTracker, and plainly otherwise.
+func (p *MessageBusProcessorBase) trackRoute(route messaging.Route, sub messaging.Subscription) {
+	if rt, ok := p.SubscriptionTracker.(RouteTracker); ok {
+		rt.TrackRoute(route, sub)
+		return
+	}
 	p.Track(sub)
-	return sub, nil
 }
 
 func (p *MessageBusProcessorBase) CurrentUser(ctx context.Context) (claims.Principal, bool) {
@@ -125,9 +213,167 @@ func (p *MessageBusProcessorBase) CurrentUserID(ctx context.Context) (uuid.UUID,
 	return id, true
 }
 
+// Shutdown stops request monitoring, unsubscribes every tracked
+// subscription and then waits for in-flight handlers to return, bounded by
+// ctx. All failures are joined.
 func (p *MessageBusProcessorBase) Shutdown(ctx context.Context) error {
-	if p.monitor != nil {
-		p.monitor.Stop()
+	p.monitorMu.Lock()
+	monitor := p.monitor
+	p.monitor = nil
+	p.unmonitored = nil
+	p.monitorMu.Unlock()
+
+	if monitor != nil {
+		monitor.Stop()
+	}
+	return errors.Join(p.SubscriptionTracker.Shutdown(ctx), p.Drain(ctx))
+}
+
+// Drain waits until no handler registered through the processor is running
+// or ctx is done.
+func (p *MessageBusProcessorBase) Drain(ctx context.Context) error {
+	return p.inflight.wait(ctx)
+}
+
+// trackMessageHandler counts handler as in flight and runs the middleware
+// pipeline in a span continuing the sender's trace.
+func (p *MessageBusProcessorBase) trackMessageHandler(route messaging.Route, handler messaging.MessageHandler) messaging.MessageHandler {
+	info := HandlerInfo{Route: route, Kind: MessageHandlerKind}
+	return func(ctx context.Context, msg messaging.Message) error {
+		p.inflight.enter()
+		defer p.inflight.leave()
+		ctx, span := p.handlerContext(ctx, info, msg)
+		err := handler(ctx, msg)
+		tracing.End(span, err)
+		return err
+	}
+}
+
+// trackRequestHandler is trackMessageHandler for request handlers.
+func (p *MessageBusProcessorBase) trackRequestHandler(route messaging.Route, handler messaging.RequestHandler) messaging.RequestHandler {
+	info := HandlerInfo{Route: route, Kind: RequestHandlerKind}
+	return func(ctx context.Context, req messaging.Request) (messaging.Response, error) {
+		p.inflight.enter()
+		defer p.inflight.leave()
+		ctx, span := p.handlerContext(ctx, info, req)
+		resp, err := handler(ctx, req)
+		tracing.End(span, err)
+		return resp, err
+	}
+}
+
+// handlerContext starts the span of a handler invocation, named after the
+// handler kind and route, as a child of the sender's span in ctx.
+func (p *MessageBusProcessorBase) handlerContext(ctx context.Context, info HandlerInfo, msg polymorphic.Polymorphic) (context.Context, trace.Span) {
+	ctx, span := startHandlerSpan(ctx, tracing.Tracer(), info, msg.GetDiscriminator())
+	return context.WithValue(ctx, inflightKey{}, &p.inflight), span
+}
+
+type inflightKey struct{}
+
+// retainInflight keeps the processor running ctx's handler from reporting
+// idle until release is called. Middleware that leaves work running after it
+// returns, such as TimeoutMiddleware, uses it so Drain still waits for that
+// work. Outside a processor it does nothing.
+func retainInflight(ctx context.Context) (release func()) {
+	t, ok := ctx.Value(inflightKey{}).(*inflightTracker)
+	if !ok {
+		return func() {}
+	}
+	t.enter()
+	return t.leave
+}
+
+// requestSubscription is the handle returned for a request handler
+// registered while monitoring is off. It follows the handler into the
+// monitor when monitoring is enabled later.
+type requestSubscription struct {
+	id      uuid.UUID
+	route   messaging.Route
+	handler messaging.RequestHandler
+	p       *MessageBusProcessorBase
+
+	mu  sync.Mutex
+	sub messaging.Subscription
+}
+
+func (s *requestSubscription) GetID() uuid.UUID {
+	return s.id
+}
+
+func (s *requestSubscription) Unsubscribe() error {
+	s.p.monitorMu.Lock()
+	delete(s.p.unmonitored, s.id)
+	s.p.monitorMu.Unlock()
+
+	s.mu.Lock()
+	sub := s.sub
+	s.sub = nil
+	s.mu.Unlock()
+	if sub == nil {
+		return nil
+	}
+	return sub.Unsubscribe()
+}
+
+// moveTo registers the handler with monitor before dropping the direct
+// subscription, so the route never lacks a responder.
+func (s *requestSubscription) moveTo(monitor *RequestHandlerMonitor) error {
+	monitored, err := monitor.Register(s.route, s.handler)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	old := s.sub
+	s.sub = monitored
+	s.mu.Unlock()
+	if old != nil {
+		// may fail if the direct subscription already died with the connection
+		_ = old.Unsubscribe()
+	}
+	return nil
+}
+
+// inflightTracker counts running handlers. Unlike sync.WaitGroup it allows
+// enter to race with wait, which happens when a message arrives during
+// shutdown.
+type inflightTracker struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{}
+}
+
+func (t *inflightTracker) enter() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.n == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.n++
+}
+
+func (t *inflightTracker) leave() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n--
+	if t.n == 0 {
+		close(t.idle)
+	}
+}
+
+func (t *inflightTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.n == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for in-flight handlers: %w", ctx.Err())
 	}
-	return p.SubscriptionTracker.Shutdown(ctx)
 }
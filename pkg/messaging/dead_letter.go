@@ -0,0 +1,254 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fgrzl/enumerators"
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+)
+
+// DeadLetter records a stream entry that still failed after its
+// FailurePolicy was exhausted.
+type DeadLetter struct {
+	Space    string            `json:"space"`
+	Segment  string            `json:"segment"`
+	Sequence uint64            `json:"sequence"`
+	Offset   lexkey.LexKey     `json:"offset"`
+	Payload  []byte            `json:"payload"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Reason   string            `json:"reason"`
+	Attempts int               `json:"attempts"`
+	FailedAt time.Time         `json:"failed_at"`
+}
+
+func newDeadLetter(entry *streamkit.Entry, err error, attempts int) *DeadLetter {
+	return &DeadLetter{
+		Space:    entry.Space,
+		Segment:  entry.Segment,
+		Sequence: entry.Sequence,
+		Offset:   entry.GetSpaceOffset(),
+		Payload:  entry.Payload,
+		Metadata: maps.Clone(entry.Metadata),
+		Reason:   err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+	}
+}
+
+// DeadLetterSink receives entries that exhausted their FailurePolicy. If
+// DeadLetter returns an error the entry is not skipped and the offset is not
+// advanced.
+type DeadLetterSink interface {
+	DeadLetter(ctx context.Context, letter *DeadLetter) error
+}
+
+// DeadLetterFunc adapts a function to a DeadLetterSink.
+type DeadLetterFunc func(ctx context.Context, letter *DeadLetter) error
+
+func (f DeadLetterFunc) DeadLetter(ctx context.Context, letter *DeadLetter) error {
+	return f(ctx, letter)
+}
+
+// FailurePolicy controls how StreamProcessorBase handles an entry whose
+// handler fails. The zero value makes a single attempt and, with no Sink,
+// stops the batch so the entry is retried on the next consume cycle.
+type FailurePolicy struct {
+	// MaxAttempts is the total number of handler invocations per entry,
+	// including the first. Values below 1 mean 1.
+	MaxAttempts int
+	// Backoff returns the delay before the given retry (1 for the first
+	// retry). Nil retries immediately.
+	Backoff func(attempt int) time.Duration
+	// Sink receives entries that failed every attempt; the offset then
+	// advances past them. Nil leaves failing entries in place.
+	Sink DeadLetterSink
+}
+
+// WithFailurePolicy sets the policy applied when a stream handler fails.
+func WithFailurePolicy(policy FailurePolicy) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.failurePolicy = policy
+	}
+}
+
+// ExponentialBackoff returns a Backoff that doubles from initial up to max.
+func ExponentialBackoff(initial, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := initial
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if max > 0 && d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// processEntry handles entry according to the throttle, deduplication and
+// failure policies. A nil result means the offset may advance past entry.
+func (p *StreamProcessorBase) processEntry(ctx context.Context, entry *streamkit.Entry) error {
+	if p.throttle != nil {
+		return p.throttle.run(ctx, func() error { return p.dispatchEntry(ctx, entry) })
+	}
+	return p.dispatchEntry(ctx, entry)
+}
+
+func (p *StreamProcessorBase) dispatchEntry(ctx context.Context, entry *streamkit.Entry) error {
+	if p.dedupStore != nil {
+		return p.processOnce(ctx, entry)
+	}
+	return p.processWithPolicy(ctx, entry)
+}
+
+// processWithPolicy retries and dead-letters entry as the failure policy
+// allows.
+func (p *StreamProcessorBase) processWithPolicy(ctx context.Context, entry *streamkit.Entry) error {
+	policy := p.failurePolicy
+	attempts := max(policy.MaxAttempts, 1)
+
+	var err error
+	if p.metrics != nil {
+		start := time.Now()
+		defer func() {
+			p.metrics.EntryHandled(ctx, entry.Space, entryDiscriminator(entry), time.Since(start), err)
+		}()
+	}
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = p.attemptEntry(ctx, entry); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		if policy.Backoff != nil {
+			if waitErr := sleepContext(ctx, policy.Backoff(attempt)); waitErr != nil {
+				return errors.Join(err, waitErr)
+			}
+		} else if ctx.Err() != nil {
+			return errors.Join(err, ctx.Err())
+		}
+	}
+
+	if policy.Sink == nil {
+		return p.reportEntryError(ctx, entry, err)
+	}
+	letter := newDeadLetter(entry, err, attempts)
+	if sinkErr := policy.Sink.DeadLetter(ctx, letter); sinkErr != nil {
+		return p.reportEntryError(ctx, entry, fmt.Errorf("failed to dead-letter entry: %w", errors.Join(err, sinkErr)))
+	}
+	p.log().WarnContext(ctx, "stream entry dead-lettered",
+		"space", entry.Space,
+		"segment", entry.Segment,
+		"sequence", entry.Sequence,
+		"attempts", attempts,
+		"reason", letter.Reason)
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileDeadLetterSink appends dead letters to a local file as JSON lines.
+type FileDeadLetterSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileDeadLetterSink opens path for appending, creating it and its parent
+// directories if needed.
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	return &FileDeadLetterSink{file: f}, nil
+}
+
+func (s *FileDeadLetterSink) DeadLetter(ctx context.Context, letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close closes the underlying file.
+func (s *FileDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// StreamDeadLetterSink produces dead letters as JSON records into a streamkit
+// space. Each sink writes to its own segment so sequences never collide with
+// other producers.
+type StreamDeadLetterSink struct {
+	stream  streamkit.Client
+	storeID uuid.UUID
+	space   string
+	segment string
+
+	mu       sync.Mutex
+	sequence uint64
+}
+
+// NewStreamDeadLetterSink creates a sink producing into space of storeID.
+func NewStreamDeadLetterSink(stream streamkit.Client, storeID uuid.UUID, space string) *StreamDeadLetterSink {
+	return &StreamDeadLetterSink{
+		stream:  stream,
+		storeID: storeID,
+		space:   space,
+		segment: uuid.NewString(),
+	}
+}
+
+func (s *StreamDeadLetterSink) DeadLetter(ctx context.Context, letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := &streamkit.Record{
+		Sequence: s.sequence + 1,
+		Payload:  data,
+		Metadata: map[string]string{"dead_letter_space": letter.Space},
+	}
+	results := s.stream.Produce(ctx, s.storeID, s.space, s.segment, enumerators.Slice([]*streamkit.Record{record}))
+	if err := enumerators.Consume(results); err != nil {
+		return fmt.Errorf("failed to produce dead letter to space %q: %w", s.space, err)
+	}
+	s.sequence = record.Sequence
+	return nil
+}
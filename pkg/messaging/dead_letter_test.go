@@ -0,0 +1,157 @@
+package messaging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFailingProcessor(t *testing.T, failures int) (*StreamProcessorBase, *int) {
+	t.Helper()
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	calls := 0
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		calls++
+		if calls <= failures {
+			return errors.New("handler failed")
+		}
+		return nil
+	}))
+	return p, &calls
+}
+
+func TestShouldRetryEntryUntilHandlerSucceeds(t *testing.T) {
+	// Arrange
+	p, calls := newFailingProcessor(t, 2)
+	WithFailurePolicy(FailurePolicy{MaxAttempts: 3})(p)
+
+	// Act
+	err := p.processEntry(context.Background(), newTestEntry(t, 1, "a"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestShouldReturnErrorWhenNoDeadLetterSinkConfigured(t *testing.T) {
+	// Arrange
+	p, calls := newFailingProcessor(t, 10)
+	WithFailurePolicy(FailurePolicy{MaxAttempts: 2})(p)
+
+	// Act
+	err := p.processEntry(context.Background(), newTestEntry(t, 1, "a"))
+
+	// Assert
+	assert.ErrorContains(t, err, "handler failed")
+	assert.Equal(t, 2, *calls)
+}
+
+func TestShouldDeadLetterEntryAfterExhaustingAttempts(t *testing.T) {
+	// Arrange
+	p, calls := newFailingProcessor(t, 10)
+	var letters []*DeadLetter
+	WithFailurePolicy(FailurePolicy{
+		MaxAttempts: 3,
+		Backoff:     ExponentialBackoff(time.Millisecond, 2*time.Millisecond),
+		Sink: DeadLetterFunc(func(ctx context.Context, letter *DeadLetter) error {
+			letters = append(letters, letter)
+			return nil
+		}),
+	})(p)
+	entry := newTestEntry(t, 7, "a")
+
+	// Act
+	err := p.processEntry(context.Background(), entry)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 3, *calls)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "test-space", letters[0].Space)
+	assert.Equal(t, uint64(7), letters[0].Sequence)
+	assert.Equal(t, entry.GetSpaceOffset(), letters[0].Offset)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.Equal(t, "handler failed", letters[0].Reason)
+}
+
+func TestShouldReturnErrorWhenDeadLetterSinkFails(t *testing.T) {
+	// Arrange
+	p, _ := newFailingProcessor(t, 10)
+	sinkErr := errors.New("sink down")
+	WithFailurePolicy(FailurePolicy{
+		Sink: DeadLetterFunc(func(ctx context.Context, letter *DeadLetter) error { return sinkErr }),
+	})(p)
+
+	// Act
+	err := p.processEntry(context.Background(), newTestEntry(t, 1, "a"))
+
+	// Assert
+	assert.ErrorIs(t, err, sinkErr)
+}
+
+func TestShouldStopRetryingWhenContextCanceled(t *testing.T) {
+	// Arrange
+	p, calls := newFailingProcessor(t, 10)
+	WithFailurePolicy(FailurePolicy{
+		MaxAttempts: 5,
+		Backoff:     func(int) time.Duration { return time.Hour },
+	})(p)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err := p.processEntry(ctx, newTestEntry(t, 1, "a"))
+
+	// Assert
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestShouldGrowExponentialBackoffUpToMax(t *testing.T) {
+	// Arrange
+	backoff := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+
+	// Act & Assert
+	assert.Equal(t, 10*time.Millisecond, backoff(1))
+	assert.Equal(t, 20*time.Millisecond, backoff(2))
+	assert.Equal(t, 40*time.Millisecond, backoff(3))
+	assert.Equal(t, 50*time.Millisecond, backoff(4))
+}
+
+func TestShouldAppendDeadLettersToFile(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "dlq", "letters.jsonl")
+	sink, err := NewFileDeadLetterSink(path)
+	require.NoError(t, err)
+	letter := newDeadLetter(newTestEntry(t, 3, "a"), errors.New("boom"), 2)
+
+	// Act
+	require.NoError(t, sink.DeadLetter(context.Background(), letter))
+	require.NoError(t, sink.DeadLetter(context.Background(), letter))
+	require.NoError(t, sink.Close())
+
+	// Assert
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var lines int
+	for scanner.Scan() {
+		var got DeadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &got))
+		assert.Equal(t, "boom", got.Reason)
+		assert.Equal(t, 2, got.Attempts)
+		assert.Equal(t, letter.Payload, got.Payload)
+		lines++
+	}
+	assert.Equal(t, 2, lines)
+}
@@ -0,0 +1,352 @@
+package messaging
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/localstore"
+)
+
+// DefaultDedupCapacity is the number of processed keys kept by dedup stores
+// created with a capacity of zero or less.
+const DefaultDedupCapacity = 10000
+
+// DedupStore records which stream entries have been processed.
+type DedupStore interface {
+	// Seen reports whether key was marked as processed.
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark records key as processed.
+	Mark(ctx context.Context, key string) error
+}
+
+// DedupKeyFunc derives the dedup key of an entry.
+type DedupKeyFunc func(entry *streamkit.Entry) string
+
+// EntryDedupKey identifies an entry by its space, segment and sequence.
+func EntryDedupKey(entry *streamkit.Entry) string {
+	return fmt.Sprintf("%s/%s/%d", entry.Space, entry.Segment, entry.Sequence)
+}
+
+// WithDeduplication skips entries whose key is already in store before they
+// reach handlers, and marks entries in store once they have been handled or
+// dead-lettered. A nil key uses EntryDedupKey.
+func WithDeduplication(store DedupStore, key DedupKeyFunc) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		if key == nil {
+			key = EntryDedupKey
+		}
+		p.dedupStore = store
+		p.dedupKey = key
+	}
+}
+
+// DuplicateCount returns how many entries were skipped as duplicates.
+func (p *StreamProcessorBase) DuplicateCount() uint64 {
+	return p.duplicates.Load()
+}
+
+// DedupTx lets handlers take part in recording an entry as processed. Each
+// handling attempt gets its own DedupTx; handlers retrieve it with
+// DedupTxFromContext.
+type DedupTx struct {
+	// Key is the entry's dedup key. Handlers writing to their own store can
+	// save it alongside their changes to make them idempotent.
+	Key string
+
+	onCommit []func(context.Context) error
+	onAbort  []func(context.Context, error)
+}
+
+// OnCommit registers fn to run after every handler of the entry succeeded and
+// before the key is marked. If fn fails, the attempt fails, abort hooks run
+// and the entry is not marked.
+func (tx *DedupTx) OnCommit(fn func(ctx context.Context) error) {
+	tx.onCommit = append(tx.onCommit, fn)
+}
+
+// OnAbort registers fn to run with the failure when the attempt fails.
+func (tx *DedupTx) OnAbort(fn func(ctx context.Context, err error)) {
+	tx.onAbort = append(tx.onAbort, fn)
+}
+
+func (tx *DedupTx) commit(ctx context.Context) error {
+	for _, fn := range tx.onCommit {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *DedupTx) abort(ctx context.Context, err error) {
+	for _, fn := range tx.onAbort {
+		fn(ctx, err)
+	}
+}
+
+type dedupTxKey struct{}
+
+// DedupTxFromContext returns the dedup transaction of the entry being
+// handled. It is only present when deduplication is enabled.
+func DedupTxFromContext(ctx context.Context) (*DedupTx, bool) {
+	tx, ok := ctx.Value(dedupTxKey{}).(*DedupTx)
+	return tx, ok && tx != nil
+}
+
+// processOnce skips entry if it was already processed and marks it after
+// processWithPolicy succeeds.
+func (p *StreamProcessorBase) processOnce(ctx context.Context, entry *streamkit.Entry) error {
+	key := p.dedupKey(entry)
+	seen, err := p.dedupStore.Seen(ctx, key)
+	if err != nil {
+		return p.reportEntryError(ctx, entry, fmt.Errorf("failed to check dedup store: %w", err))
+	}
+	if seen {
+		p.duplicates.Add(1)
+		p.log().DebugContext(ctx, "skipping duplicate stream entry", "space", entry.Space, "key", key)
+		return nil
+	}
+
+	if err := p.processWithPolicy(ctx, entry); err != nil {
+		return err
+	}
+	if err := p.dedupStore.Mark(ctx, key); err != nil {
+		return p.reportEntryError(ctx, entry, fmt.Errorf("failed to mark entry processed: %w", err))
+	}
+	return nil
+}
+
+// attemptEntry runs the handlers once, inside a DedupTx when deduplication
+// is enabled.
+func (p *StreamProcessorBase) attemptEntry(ctx context.Context, entry *streamkit.Entry) error {
+	if p.dedupStore == nil {
+		return p.handleEntry(ctx, entry)
+	}
+	tx := &DedupTx{Key: p.dedupKey(entry)}
+	if err := p.handleEntry(context.WithValue(ctx, dedupTxKey{}, tx), entry); err != nil {
+		tx.abort(ctx, err)
+		return err
+	}
+	if err := tx.commit(ctx); err != nil {
+		err = fmt.Errorf("dedup commit hook failed: %w", err)
+		tx.abort(ctx, err)
+		return err
+	}
+	return nil
+}
+
+// MemoryDedupStore keeps the most recently marked keys in memory, evicting
+// the least recently marked once capacity is reached.
+type MemoryDedupStore struct {
+	mu   sync.Mutex
+	keys *lruSet
+}
+
+// NewMemoryDedupStore keeps up to capacity keys, DefaultDedupCapacity when
+// capacity is zero or less.
+func NewMemoryDedupStore(capacity int) *MemoryDedupStore {
+	return &MemoryDedupStore{keys: newLRUSet(capacity)}
+}
+
+func (s *MemoryDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys.contains(key), nil
+}
+
+func (s *MemoryDedupStore) Mark(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys.add(key)
+	return nil
+}
+
+// FileDedupStore bounds keys like MemoryDedupStore and appends them to a file
+// of JSON encoded keys, so processed keys survive restarts. The file is compacted to
+// the retained keys once it holds twice the capacity.
+type FileDedupStore struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	keys  *lruSet
+	lines int
+}
+
+// NewFileDedupStore opens or creates the store at path, loading the newest
+// capacity keys.
+func NewFileDedupStore(path string, capacity int) (*FileDedupStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dedup directory: %w", err)
+	}
+	s := &FileDedupStore{path: path, keys: newLRUSet(capacity)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dedup file: %w", err)
+	}
+	s.file = f
+	return s, nil
+}
+
+// NewTenantFileDedupStore stores keys for name under the tenant's data path,
+// see localstore.GetDataPath.
+func NewTenantFileDedupStore(tenantID uuid.UUID, name string, capacity int) (*FileDedupStore, error) {
+	dataPath, err := localstore.GetDataPath(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileDedupStore(filepath.Join(dataPath, "dedup", fileName(name)+".jsonl"), capacity)
+}
+
+func (s *FileDedupStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open dedup file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var key string
+		if err := json.Unmarshal(scanner.Bytes(), &key); err != nil {
+			// a torn final line from a crash is dropped
+			continue
+		}
+		s.keys.add(key)
+		s.lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read dedup file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys.contains(key), nil
+}
+
+func (s *FileDedupStore) Mark(ctx context.Context, key string) error {
+	line, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if s.keys.contains(key) {
+		return nil
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write dedup file: %w", err)
+	}
+	s.keys.add(key)
+	s.lines++
+	if s.lines > 2*s.keys.capacity {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact rewrites the file with only the retained keys and appends to the
+// rewritten file from then on. If compaction fails the current file is kept
+// and compaction is retried on the next Mark. The caller must hold s.mu.
+func (s *FileDedupStore) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create dedup file: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	keys := s.keys.oldestFirst()
+	for _, key := range keys {
+		line, _ := json.Marshal(key)
+		w.Write(append(line, '\n'))
+	}
+	if err := errors.Join(w.Flush(), tmp.Sync()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write dedup file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to replace dedup file: %w", err)
+	}
+
+	s.file.Close()
+	s.file = tmp
+	s.lines = len(keys)
+	return nil
+}
+
+// Close closes the underlying file.
+func (s *FileDedupStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// lruSet is a set of strings bounded to capacity, evicting the least
+// recently added key. It is not safe for concurrent use.
+type lruSet struct {
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+func newLRUSet(capacity int) *lruSet {
+	if capacity <= 0 {
+		capacity = DefaultDedupCapacity
+	}
+	return &lruSet{capacity: capacity, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (s *lruSet) contains(key string) bool {
+	_, ok := s.items[key]
+	return ok
+}
+
+func (s *lruSet) add(key string) {
+	if el, ok := s.items[key]; ok {
+		s.order.MoveToFront(el)
+		return
+	}
+	s.items[key] = s.order.PushFront(key)
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(string))
+	}
+}
+
+func (s *lruSet) oldestFirst() []string {
+	keys := make([]string, 0, s.order.Len())
+	for el := s.order.Back(); el != nil; el = el.Prev() {
+		keys = append(keys, el.Value.(string))
+	}
+	return keys
+}
@@ -0,0 +1,142 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldSkipDuplicateEntries(t *testing.T) {
+	// Arrange
+	p, calls := newFailingProcessor(t, 0)
+	WithDeduplication(NewMemoryDedupStore(10), nil)(p)
+	entry := newTestEntry(t, 1, "a")
+
+	// Act
+	require.NoError(t, p.processEntry(context.Background(), entry))
+	err := p.processEntry(context.Background(), entry)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, uint64(1), p.DuplicateCount())
+}
+
+func TestShouldNotMarkFailedEntries(t *testing.T) {
+	// Arrange
+	p, calls := newFailingProcessor(t, 1)
+	store := NewMemoryDedupStore(10)
+	WithDeduplication(store, nil)(p)
+	entry := newTestEntry(t, 1, "a")
+
+	// Act
+	require.Error(t, p.processEntry(context.Background(), entry))
+	err := p.processEntry(context.Background(), entry)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+	seen, err := store.Seen(context.Background(), EntryDedupKey(entry))
+	require.NoError(t, err)
+	assert.True(t, seen)
+}
+
+func TestShouldFailEntryWhenCommitHookFails(t *testing.T) {
+	// Arrange
+	p, _ := newFailingProcessor(t, 0)
+	store := NewMemoryDedupStore(10)
+	WithDeduplication(store, nil)(p)
+	var aborted error
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		tx, ok := DedupTxFromContext(ctx)
+		require.True(t, ok)
+		tx.OnCommit(func(ctx context.Context) error { return errors.New("commit failed") })
+		tx.OnAbort(func(ctx context.Context, err error) { aborted = err })
+		return nil
+	}))
+	entry := newTestEntry(t, 1, "a")
+
+	// Act
+	err := p.processEntry(context.Background(), entry)
+
+	// Assert
+	assert.ErrorContains(t, err, "commit failed")
+	assert.ErrorContains(t, aborted, "commit failed")
+	seen, _ := store.Seen(context.Background(), EntryDedupKey(entry))
+	assert.False(t, seen)
+}
+
+func TestShouldEvictLeastRecentlyMarkedKeys(t *testing.T) {
+	// Arrange
+	store := NewMemoryDedupStore(2)
+	ctx := context.Background()
+
+	// Act
+	require.NoError(t, store.Mark(ctx, "a"))
+	require.NoError(t, store.Mark(ctx, "b"))
+	require.NoError(t, store.Mark(ctx, "c"))
+
+	// Assert
+	seenA, _ := store.Seen(ctx, "a")
+	seenC, _ := store.Seen(ctx, "c")
+	assert.False(t, seenA)
+	assert.True(t, seenC)
+}
+
+func TestShouldReloadFileDedupStoreAfterCompaction(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "dedup.jsonl")
+	store, err := NewFileDedupStore(path, 2)
+	require.NoError(t, err)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, store.Mark(ctx, key))
+	}
+	require.NoError(t, store.Close())
+
+	// Act
+	reopened, err := NewFileDedupStore(path, 2)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	// Assert
+	for key, want := range map[string]bool{"a": false, "c": false, "d": true, "e": true} {
+		seen, err := reopened.Seen(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, want, seen, key)
+	}
+}
+
+func TestShouldKeepMarkingAfterFailedCompaction(t *testing.T) {
+	// Arrange
+	dir := filepath.Join(t.TempDir(), "dedup")
+	path := filepath.Join(dir, "dedup.jsonl")
+	store, err := NewFileDedupStore(path, 1)
+	require.NoError(t, err)
+	defer store.Close()
+	ctx := context.Background()
+	require.NoError(t, store.Mark(ctx, "a"))
+	require.NoError(t, store.Mark(ctx, "b"))
+	require.NoError(t, os.RemoveAll(dir))
+	require.Error(t, store.Mark(ctx, "c"), "compaction should fail without its directory")
+	require.NoError(t, os.MkdirAll(dir, 0700))
+
+	// Act
+	err = store.Mark(ctx, "d")
+
+	// Assert
+	require.NoError(t, err)
+	require.NoError(t, store.Mark(ctx, "e"))
+	require.NoError(t, store.Close())
+	reopened, err := NewFileDedupStore(path, 1)
+	require.NoError(t, err)
+	defer reopened.Close()
+	seen, err := reopened.Seen(ctx, "e")
+	require.NoError(t, err)
+	assert.True(t, seen)
+}
@@ -0,0 +1,297 @@
+// Package loopback provides an in-process messaging.MessageBus for unit
+// tests and single-process development. It routes messages the way the NATS
+// bus does: routes resolve to the same subjects, tenant and inbox routes
+// without an ID subscribe to every tenant or inbox, messages are serialized
+// in the polymorphic envelope, and only the correlation ID, causation ID,
+// serialized user principal and W3C trace context travel with a message.
+//
+// membus, the in-memory bus of the messaging module, is not enough for
+// code that will run on NATS, and cannot be wrapped to become so: it keys
+// handlers by exact route, so a handler subscribed to every tenant never
+// receives a tenant's messages; it hands handlers the sender's message and
+// context, so unregistered types, principal claims lost in transit and the
+// caller's deadline go unnoticed; it returns handler errors to the requester
+// instead of a messaging.ErrorResponse; and it allows one request handler
+// per route, whose subscriptions all remove it, so replacing a handler as
+// RequestHandlerMonitor does drops it.
+package loopback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fgrzl/claims"
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/tracing"
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	// ErrClosed is returned by a Bus after Close.
+	ErrClosed = errors.New("loopback bus is closed")
+	// ErrNoResponders is returned for a request no handler is subscribed to.
+	ErrNoResponders = errors.New("no responders available for request")
+)
+
+// Bus is an in-process messaging.MessageBus. Unlike NATS, Notify returns
+// once every subscriber has handled the message, which keeps tests
+// deterministic. Requests are delivered to every matching handler and the
+// first reply wins. It is safe for concurrent use.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   []*subscription
+	closed bool
+}
+
+// New creates an empty Bus.
+func New() *Bus {
+	return &Bus{}
+}
+
+// NewFactory returns a factory that always returns bus.
+func NewFactory(bus *Bus) messaging.MessageBusFactory {
+	return &factory{bus: bus}
+}
+
+type factory struct {
+	bus *Bus
+}
+
+func (f *factory) Get(ctx context.Context) (messaging.MessageBus, error) {
+	return f.bus, nil
+}
+
+type subscription struct {
+	id             uuid.UUID
+	bus            *Bus
+	subject        []string
+	handler        messaging.MessageHandler
+	requestHandler messaging.RequestHandler
+}
+
+func (s *subscription) GetID() uuid.UUID {
+	return s.id
+}
+
+func (s *subscription) Unsubscribe() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	for i, sub := range s.bus.subs {
+		if sub == s {
+			s.bus.subs = append(s.bus.subs[:i:i], s.bus.subs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (b *Bus) Notify(msg messaging.Message) error {
+	return b.NotifyWithContext(context.Background(), msg)
+}
+
+func (b *Bus) NotifyWithContext(ctx context.Context, msg messaging.Message) error {
+	subject := Subject(msg.GetRoute())
+	data, err := polymorphic.MarshalPolymorphicJSON(msg)
+	if err != nil {
+		return fmt.Errorf("failed to serialize notification for %s: %w", subject, err)
+	}
+	subs, err := b.matching(subject, false)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		hctx := handlerContext(ctx)
+		decoded, err := decode[messaging.Message](data)
+		if err != nil {
+			slog.ErrorContext(hctx, "failed to deserialize message", "route", subject, "error", err)
+			continue
+		}
+		if err := sub.handler(hctx, decoded); err != nil {
+			slog.WarnContext(hctx, "message handler error", "route", subject, "error", err)
+		}
+	}
+	return nil
+}
+
+func (b *Bus) Request(msg messaging.Request, timeout time.Duration) (messaging.Response, error) {
+	return b.RequestWithContext(context.Background(), msg, timeout)
+}
+
+func (b *Bus) RequestWithContext(ctx context.Context, msg messaging.Request, timeout time.Duration) (messaging.Response, error) {
+	subject := Subject(msg.GetRoute())
+	data, err := polymorphic.MarshalPolymorphicJSON(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize request for %s: %w", subject, err)
+	}
+	subs, err := b.matching(subject, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("%s: %w", subject, ErrNoResponders)
+	}
+
+	replies := make(chan []byte, len(subs))
+	for _, sub := range subs {
+		go func() {
+			replies <- handleRequest(handlerContext(ctx), subject, data, sub.requestHandler)
+		}()
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case reply := <-replies:
+		return decode[messaging.Response](reply)
+	case <-waitCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("request to %s timed out after %s: %w", subject, timeout, context.DeadlineExceeded)
+	}
+}
+
+// handleRequest runs handler and returns its encoded reply. Failures are
+// answered with a messaging.ErrorResponse, as the NATS bus does.
+func handleRequest(ctx context.Context, subject string, data []byte, handler messaging.RequestHandler) []byte {
+	var resp messaging.Response
+	req, err := decode[messaging.Request](data)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to deserialize request", "route", subject, "error", err)
+		resp = &messaging.ErrorResponse{Error: "Invalid request format"}
+	} else if resp, err = handler(ctx, req); err != nil {
+		slog.WarnContext(ctx, "request handler error", "route", subject, "error", err)
+		resp = &messaging.ErrorResponse{Error: err.Error()}
+	}
+
+	reply, err := polymorphic.MarshalPolymorphicJSON(resp)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to serialize response", "route", subject, "error", err)
+		reply, _ = polymorphic.MarshalPolymorphicJSON(&messaging.ErrorResponse{Error: err.Error()})
+	}
+	return reply
+}
+
+func (b *Bus) Subscribe(route messaging.Route, handler messaging.MessageHandler) (messaging.Subscription, error) {
+	return b.subscribe(&subscription{handler: handler}, route)
+}
+
+func (b *Bus) SubscribeRequest(route messaging.Route, handler messaging.RequestHandler) (messaging.Subscription, error) {
+	return b.subscribe(&subscription{requestHandler: handler}, route)
+}
+
+func (b *Bus) subscribe(sub *subscription, route messaging.Route) (messaging.Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	sub.id = uuid.New()
+	sub.bus = b
+	sub.subject = strings.Split(Subject(route), ".")
+	b.subs = append(b.subs, sub)
+	return sub, nil
+}
+
+// Close removes every subscription. Later calls fail with ErrClosed.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.subs = nil
+	return nil
+}
+
+// matching returns the message or request subscriptions whose subject
+// matches subject.
+func (b *Bus) matching(subject string, requests bool) ([]*subscription, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	tokens := strings.Split(subject, ".")
+	var subs []*subscription
+	for _, sub := range b.subs {
+		if (sub.requestHandler != nil) == requests && subjectMatches(sub.subject, tokens) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+// Subject returns the NATS subject route resolves to. Tenant and inbox
+// routes without an ID resolve to a wildcard matching every ID.
+func Subject(route messaging.Route) string {
+	if route.Scope == messaging.ScopeTenant || route.Scope == messaging.ScopeInbox {
+		id := "*"
+		if route.ID != nil {
+			id = route.ID.String()
+		}
+		return fmt.Sprintf("%s.%s.%s.%s", route.Scope, id, route.Area, route.Name)
+	}
+	return fmt.Sprintf("%s.%s.%s", route.Scope, route.Area, route.Name)
+}
+
+// subjectMatches applies NATS wildcard rules: "*" matches one token and a
+// trailing ">" matches one or more.
+func subjectMatches(pattern, tokens []string) bool {
+	for i, p := range pattern {
+		if p == ">" {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) || (p != "*" && p != tokens[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(tokens)
+}
+
+// handlerContext carries over what the NATS bus transports in headers and
+// drops everything else, including the caller's deadline. The user principal
+// and span context are serialized and deserialized as in a header, so
+// handlers see the reconstructed principal and remote parent span NATS
+// handlers see.
+func handlerContext(ctx context.Context) context.Context {
+	header := nats.Header{}
+	tracing.InjectHeader(ctx, header)
+	hctx := tracing.ExtractHeader(context.Background(), header)
+	if correlationID, causationID := messaging.GetTracing(ctx); correlationID != uuid.Nil || causationID != uuid.Nil {
+		hctx = messaging.ContextWithTracing(hctx, correlationID, causationID)
+	}
+	if user, ok := messaging.GetUserPrincipal(ctx); ok {
+		header, err := claims.SerializePrincipal(user)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to serialize user principal", "error", err)
+			return hctx
+		}
+		received, err := claims.DeserializePrincipal(header)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to deserialize user principal", "error", err)
+			return hctx
+		}
+		hctx = messaging.ContextWithUserPrincipal(hctx, received)
+	}
+	return hctx
+}
+
+func decode[T polymorphic.Polymorphic](data []byte) (T, error) {
+	var zero T
+	envelope, err := polymorphic.UnmarshalPolymorphicJSON(data)
+	if err != nil {
+		return zero, err
+	}
+	content, ok := envelope.Content.(T)
+	if !ok {
+		return zero, fmt.Errorf("unexpected discriminator %q", envelope.Discriminator)
+	}
+	return content, nil
+}
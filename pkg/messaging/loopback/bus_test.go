@@ -0,0 +1,261 @@
+package loopback
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/claims"
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	polymorphic.RegisterType[testEvent]()
+	polymorphic.RegisterType[testQuery]()
+}
+
+type testEvent struct {
+	TenantID uuid.UUID `json:"tenant_id"`
+	Value    string    `json:"value"`
+}
+
+func (*testEvent) GetDiscriminator() string { return "mesh://loopback/event" }
+
+func (e *testEvent) GetRoute() messaging.Route {
+	return messaging.NewTenantRoute("test", "event", &e.TenantID)
+}
+
+type testQuery struct {
+	Value string `json:"value"`
+}
+
+func (*testQuery) GetDiscriminator() string { return "mesh://loopback/query" }
+
+func (*testQuery) GetRoute() messaging.Route { return messaging.NewGlobalRoute("test", "query") }
+
+func TestShouldResolveSubjectsLikeNats(t *testing.T) {
+	tenantID := uuid.MustParse("6f1c3c56-8f3b-4b8e-9d43-4c0f2f1e9a10")
+	tests := []struct {
+		name  string
+		route messaging.Route
+		want  string
+	}{
+		{name: "global", route: messaging.NewGlobalRoute("leases", "acquire"), want: "global.leases.acquire"},
+		{name: "tenant", route: messaging.NewTenantRoute("leases", "acquire", &tenantID), want: "tenant.6f1c3c56-8f3b-4b8e-9d43-4c0f2f1e9a10.leases.acquire"},
+		{name: "any tenant", route: messaging.NewTenantRoute("leases", "acquire", nil), want: "tenant.*.leases.acquire"},
+		{name: "any inbox", route: messaging.NewInboxRoute("leases", "acquire", nil), want: "inbox.*.leases.acquire"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			got := Subject(tt.route)
+
+			// Assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestShouldDeliverNotificationsToMatchingSubscribers(t *testing.T) {
+	// Arrange
+	bus := New()
+	tenantID, otherID := uuid.New(), uuid.New()
+	var anyTenant, sameTenant, otherTenant []string
+	record := func(values *[]string) messaging.MessageHandler {
+		return func(ctx context.Context, msg messaging.Message) error {
+			*values = append(*values, msg.(*testEvent).Value)
+			return nil
+		}
+	}
+	_, err := bus.Subscribe(messaging.NewTenantRoute("test", "event", nil), record(&anyTenant))
+	require.NoError(t, err)
+	_, err = bus.Subscribe(messaging.NewTenantRoute("test", "event", &tenantID), record(&sameTenant))
+	require.NoError(t, err)
+	_, err = bus.Subscribe(messaging.NewTenantRoute("test", "event", &otherID), record(&otherTenant))
+	require.NoError(t, err)
+
+	// Act
+	err = bus.Notify(&testEvent{TenantID: tenantID, Value: "a"})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, anyTenant)
+	assert.Equal(t, []string{"a"}, sameTenant)
+	assert.Empty(t, otherTenant)
+}
+
+func TestShouldStopDeliveringAfterUnsubscribe(t *testing.T) {
+	// Arrange
+	bus := New()
+	calls := 0
+	sub, err := bus.Subscribe(messaging.NewTenantRoute("test", "event", nil), func(context.Context, messaging.Message) error {
+		calls++
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, bus.Notify(&testEvent{Value: "a"}))
+
+	// Act
+	require.NoError(t, sub.Unsubscribe())
+	err = bus.Notify(&testEvent{Value: "b"})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestShouldRouteRequestsToHandlers(t *testing.T) {
+	// Arrange
+	bus := New()
+	sent := &testQuery{Value: "ping"}
+	_, err := bus.SubscribeRequest(sent.GetRoute(), func(ctx context.Context, req messaging.Request) (messaging.Response, error) {
+		return &messaging.Accepted{Reason: req.(*testQuery).Value}, nil
+	})
+	require.NoError(t, err)
+
+	// Act
+	resp, err := messaging.SendRequestWithContext[*testQuery, *messaging.Accepted](context.Background(), bus, sent, time.Second)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "ping", resp.Reason)
+}
+
+func TestShouldReplyWithErrorResponseWhenHandlerFails(t *testing.T) {
+	// Arrange
+	bus := New()
+	_, err := bus.SubscribeRequest(messaging.NewGlobalRoute("test", "query"), func(context.Context, messaging.Request) (messaging.Response, error) {
+		return nil, errors.New("boom")
+	})
+	require.NoError(t, err)
+
+	// Act
+	resp, err := bus.Request(&testQuery{}, time.Second)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, &messaging.ErrorResponse{Error: "boom"}, resp)
+}
+
+func TestShouldFailRequestsWithoutResponders(t *testing.T) {
+	// Arrange
+	bus := New()
+
+	// Act
+	_, err := bus.Request(&testQuery{}, time.Second)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrNoResponders)
+}
+
+func TestShouldTimeOutSlowRequests(t *testing.T) {
+	// Arrange
+	bus := New()
+	release := make(chan struct{})
+	defer close(release)
+	_, err := bus.SubscribeRequest(messaging.NewGlobalRoute("test", "query"), func(context.Context, messaging.Request) (messaging.Response, error) {
+		<-release
+		return &messaging.Accepted{}, nil
+	})
+	require.NoError(t, err)
+
+	// Act
+	_, err = bus.Request(&testQuery{}, 10*time.Millisecond)
+
+	// Assert
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestShouldPropagateOnlyTransportedContext(t *testing.T) {
+	// Arrange
+	bus := New()
+	correlationID, causationID := uuid.New(), uuid.New()
+	var got context.Context
+	_, err := bus.SubscribeRequest(messaging.NewGlobalRoute("test", "query"), func(ctx context.Context, req messaging.Request) (messaging.Response, error) {
+		got = ctx
+		return &messaging.Accepted{}, nil
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(messaging.ContextWithTracing(context.Background(), correlationID, causationID), time.Minute)
+	defer cancel()
+
+	// Act
+	_, err = bus.RequestWithContext(ctx, &testQuery{}, time.Second)
+
+	// Assert
+	require.NoError(t, err)
+	gotCorrelation, gotCausation := messaging.GetTracing(got)
+	assert.Equal(t, correlationID, gotCorrelation)
+	assert.Equal(t, causationID, gotCausation)
+	_, hasDeadline := got.Deadline()
+	assert.False(t, hasDeadline)
+}
+
+func TestShouldDeliverPrincipalAsSerializedOverNats(t *testing.T) {
+	// Arrange
+	bus := New()
+	subject := uuid.NewString()
+	sent := claims.NewPrincipal(claims.NewClaimsSet(subject).Set("tenant_id", uuid.NewString()))
+	var got claims.Principal
+	_, err := bus.SubscribeRequest(messaging.NewGlobalRoute("test", "query"), func(ctx context.Context, req messaging.Request) (messaging.Response, error) {
+		got, _ = messaging.GetUserPrincipal(ctx)
+		return &messaging.Accepted{}, nil
+	})
+	require.NoError(t, err)
+
+	// Act
+	_, err = bus.RequestWithContext(messaging.ContextWithUserPrincipal(context.Background(), sent), &testQuery{}, time.Second)
+
+	// Assert
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.IsType(t, &claims.ReconstructedPrincipal{}, got)
+	assert.Equal(t, subject, got.Subject())
+	assert.Nil(t, got.CustomClaim("tenant_id"))
+}
+
+func TestShouldDeliverSenderSpanAsRemoteParent(t *testing.T) {
+	// Arrange
+	bus := New()
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	})
+	var got trace.SpanContext
+	_, err := bus.SubscribeRequest(messaging.NewGlobalRoute("test", "query"), func(ctx context.Context, req messaging.Request) (messaging.Response, error) {
+		got = trace.SpanContextFromContext(ctx)
+		return &messaging.Accepted{}, nil
+	})
+	require.NoError(t, err)
+
+	// Act
+	_, err = bus.RequestWithContext(trace.ContextWithSpanContext(context.Background(), sc), &testQuery{}, time.Second)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, sc.TraceID(), got.TraceID())
+	assert.Equal(t, sc.SpanID(), got.SpanID())
+	assert.True(t, got.IsRemote())
+}
+
+func TestShouldRejectCallsAfterClose(t *testing.T) {
+	// Arrange
+	bus := New()
+	require.NoError(t, bus.Close())
+
+	// Act
+	_, subErr := bus.Subscribe(messaging.NewGlobalRoute("test", "query"), nil)
+	notifyErr := bus.Notify(&testEvent{})
+
+	// Assert
+	assert.ErrorIs(t, subErr, ErrClosed)
+	assert.ErrorIs(t, notifyErr, ErrClosed)
+}
@@ -0,0 +1,310 @@
+// Package memstream provides an in-memory streamkit client for tests and
+// local development. Entries are kept per store and space in offset order,
+// Consume resumes after the offsets it is given, and every append notifies
+// the space's subscribers with a segment status, as a streamkit server does.
+//
+// Client implements the methods the SDK's stream consumers, producers and
+// offset stores call: Consume, ConsumeSegment, GetSegments, Peek,
+// SubscribeToSpace and Produce.
+package memstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fgrzl/enumerators"
+	"github.com/fgrzl/lexkey"
+	"github.com/fgrzl/streamkit/pkg/api"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+)
+
+// ErrSequenceConflict is returned by Produce when a record's sequence does
+// not directly follow the last sequence of its segment.
+var ErrSequenceConflict = errors.New("sequence conflict")
+
+// Client is an in-memory stream store. It is safe for concurrent use.
+type Client struct {
+	mu         sync.Mutex
+	spaces     map[spaceKey][]*streamkit.Entry
+	segments   map[segmentKey][]*streamkit.Entry
+	subs       map[spaceKey][]*subscription
+	lastTime   int64
+	consumeErr error
+}
+
+type spaceKey struct {
+	storeID uuid.UUID
+	space   string
+}
+
+type segmentKey struct {
+	spaceKey
+	segment string
+}
+
+// New creates an empty Client.
+func New() *Client {
+	return &Client{
+		spaces:   make(map[spaceKey][]*streamkit.Entry),
+		segments: make(map[segmentKey][]*streamkit.Entry),
+		subs:     make(map[spaceKey][]*subscription),
+	}
+}
+
+// Append adds records to segment of space in storeID, numbering them after
+// the segment's last sequence; the records' own sequences are ignored. It
+// notifies subscribers and returns the appended entries.
+func (c *Client) Append(storeID uuid.UUID, space, segment string, records ...*streamkit.Record) []*streamkit.Entry {
+	c.mu.Lock()
+	key := segmentKey{spaceKey{storeID, space}, segment}
+	entries := c.appendLocked(key, records)
+	status, subs := c.statusLocked(key, entries)
+	c.mu.Unlock()
+
+	notify(subs, status)
+	return entries
+}
+
+// Entries returns the entries of space in storeID in offset order.
+func (c *Client) Entries(storeID uuid.UUID, space string) []*streamkit.Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cloneEntries(c.spaces[spaceKey{storeID, space}])
+}
+
+// FailConsume makes every Consume, ConsumeSegment and Peek call fail with
+// err until it is called again with nil.
+func (c *Client) FailConsume(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.consumeErr = err
+}
+
+// Consume returns the entries of each space in args after that space's
+// offset, space by space in name order. An empty offset reads the space from
+// the start.
+func (c *Client) Consume(ctx context.Context, storeID uuid.UUID, args *streamkit.Consume) enumerators.Enumerator[*streamkit.Entry] {
+	if err := ctx.Err(); err != nil {
+		return enumerators.Error[*streamkit.Entry](err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.consumeErr != nil {
+		return enumerators.Error[*streamkit.Entry](c.consumeErr)
+	}
+
+	var spaces []string
+	if args != nil {
+		for space := range args.Offsets {
+			spaces = append(spaces, space)
+		}
+	}
+	slices.Sort(spaces)
+
+	var entries []*streamkit.Entry
+	for _, space := range spaces {
+		offset := args.Offsets[space]
+		all := c.spaces[spaceKey{storeID, space}]
+		start, _ := slices.BinarySearchFunc(all, offset, func(entry *streamkit.Entry, offset lexkey.LexKey) int {
+			if bytes.Compare(entry.GetSpaceOffset(), offset) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		entries = append(entries, cloneEntries(all[start:])...)
+	}
+	return enumerators.Slice(entries)
+}
+
+// ConsumeSegment returns the entries of a single segment whose sequence is
+// within [MinSequence, MaxSequence]; a zero MaxSequence means no upper bound.
+func (c *Client) ConsumeSegment(ctx context.Context, storeID uuid.UUID, args *streamkit.ConsumeSegment) enumerators.Enumerator[*streamkit.Entry] {
+	if err := ctx.Err(); err != nil {
+		return enumerators.Error[*streamkit.Entry](err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.consumeErr != nil {
+		return enumerators.Error[*streamkit.Entry](c.consumeErr)
+	}
+
+	all := c.segments[segmentKey{spaceKey{storeID, args.Space}, args.Segment}]
+	// sequences start at 1 and are contiguous, so they index the segment
+	start := min(uint64(len(all)), max(args.MinSequence, 1)-1)
+	end := uint64(len(all))
+	if args.MaxSequence > 0 {
+		end = max(start, min(end, args.MaxSequence))
+	}
+	return enumerators.Slice(cloneEntries(all[start:end]))
+}
+
+// GetSegments returns the names of the segments of space in storeID in name
+// order.
+func (c *Client) GetSegments(ctx context.Context, storeID uuid.UUID, space string) enumerators.Enumerator[string] {
+	if err := ctx.Err(); err != nil {
+		return enumerators.Error[string](err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var segments []string
+	for key := range c.segments {
+		if key.spaceKey == (spaceKey{storeID, space}) {
+			segments = append(segments, key.segment)
+		}
+	}
+	slices.Sort(segments)
+	return enumerators.Slice(segments)
+}
+
+// Peek returns the last entry of segment, or nil when the segment is empty.
+func (c *Client) Peek(ctx context.Context, storeID uuid.UUID, space, segment string) (*streamkit.Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.consumeErr != nil {
+		return nil, c.consumeErr
+	}
+	all := c.segments[segmentKey{spaceKey{storeID, space}, segment}]
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return cloneEntries(all[len(all)-1:])[0], nil
+}
+
+// SubscribeToSpace calls handler with the status of every later append to
+// space in storeID until the subscription is unsubscribed or ctx ends.
+// handler runs on the appending goroutine and must not block.
+func (c *Client) SubscribeToSpace(ctx context.Context, storeID uuid.UUID, space string, handler func(*streamkit.SegmentStatus)) (api.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := spaceKey{storeID, space}
+	sub := &subscription{client: c, key: key, handler: handler}
+
+	c.mu.Lock()
+	c.subs[key] = append(c.subs[key], sub)
+	sub.stop = context.AfterFunc(ctx, sub.Unsubscribe)
+	c.mu.Unlock()
+	return sub, nil
+}
+
+// Produce appends records to segment of space in storeID. Each record's
+// sequence must follow the segment's last sequence by one; the first record
+// that does not fails the call with ErrSequenceConflict and is not written.
+func (c *Client) Produce(ctx context.Context, storeID uuid.UUID, space, segment string, records enumerators.Enumerator[*streamkit.Record]) enumerators.Enumerator[*streamkit.SegmentStatus] {
+	batch, err := enumerators.ToSlice(records)
+	if err != nil {
+		return enumerators.Error[*streamkit.SegmentStatus](err)
+	}
+	if err := ctx.Err(); err != nil {
+		return enumerators.Error[*streamkit.SegmentStatus](err)
+	}
+
+	c.mu.Lock()
+	key := segmentKey{spaceKey{storeID, space}, segment}
+	last := uint64(len(c.segments[key]))
+	for i, record := range batch {
+		if record.Sequence != last+uint64(i)+1 {
+			err = fmt.Errorf("%w: segment %s/%s expected sequence %d, got %d", ErrSequenceConflict, space, segment, last+uint64(i)+1, record.Sequence)
+			batch = batch[:i]
+			break
+		}
+	}
+	entries := c.appendLocked(key, batch)
+	status, subs := c.statusLocked(key, entries)
+	c.mu.Unlock()
+
+	notify(subs, status)
+	if err != nil {
+		return enumerators.Error[*streamkit.SegmentStatus](err)
+	}
+	if status == nil {
+		return enumerators.Empty[*streamkit.SegmentStatus]()
+	}
+	return enumerators.Slice([]*streamkit.SegmentStatus{status})
+}
+
+// appendLocked writes records as entries after the segment's last sequence.
+// Timestamps strictly increase so new entries always sort last in their
+// space. The caller must hold c.mu.
+func (c *Client) appendLocked(key segmentKey, records []*streamkit.Record) []*streamkit.Entry {
+	entries := make([]*streamkit.Entry, 0, len(records))
+	for _, record := range records {
+		c.lastTime = max(time.Now().UnixNano(), c.lastTime+1)
+		entry := &streamkit.Entry{
+			Sequence:  uint64(len(c.segments[key])) + 1,
+			Timestamp: c.lastTime,
+			Payload:   slices.Clone(record.Payload),
+			Metadata:  maps.Clone(record.Metadata),
+			Space:     key.space,
+			Segment:   key.segment,
+		}
+		c.spaces[key.spaceKey] = append(c.spaces[key.spaceKey], entry)
+		c.segments[key] = append(c.segments[key], entry)
+		entries = append(entries, entry)
+	}
+	return cloneEntries(entries)
+}
+
+// statusLocked describes appended entries for the space's subscribers. The
+// caller must hold c.mu.
+func (c *Client) statusLocked(key segmentKey, entries []*streamkit.Entry) (*streamkit.SegmentStatus, []*subscription) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	status := &streamkit.SegmentStatus{
+		Space:         key.space,
+		Segment:       key.segment,
+		FirstSequence: entries[0].Sequence,
+		LastSequence:  entries[len(entries)-1].Sequence,
+	}
+	return status, slices.Clone(c.subs[key.spaceKey])
+}
+
+func notify(subs []*subscription, status *streamkit.SegmentStatus) {
+	for _, sub := range subs {
+		copied := *status
+		sub.handler(&copied)
+	}
+}
+
+type subscription struct {
+	client  *Client
+	key     spaceKey
+	handler func(*streamkit.SegmentStatus)
+	stop    func() bool
+}
+
+// Unsubscribe stops notifications. It is safe to call more than once.
+func (s *subscription) Unsubscribe() {
+	s.client.mu.Lock()
+	s.client.subs[s.key] = slices.DeleteFunc(s.client.subs[s.key], func(sub *subscription) bool {
+		return sub == s
+	})
+	stop := s.stop
+	s.client.mu.Unlock()
+	stop()
+}
+
+func cloneEntries(entries []*streamkit.Entry) []*streamkit.Entry {
+	cloned := make([]*streamkit.Entry, len(entries))
+	for i, entry := range entries {
+		copied := *entry
+		copied.Metadata = maps.Clone(entry.Metadata)
+		cloned[i] = &copied
+	}
+	return cloned
+}
@@ -0,0 +1,193 @@
+package memstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/enumerators"
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func record(payload string) *streamkit.Record {
+	return &streamkit.Record{Payload: []byte(payload)}
+}
+
+func payloads(t *testing.T, entries enumerators.Enumerator[*streamkit.Entry]) []string {
+	t.Helper()
+	all, err := enumerators.ToSlice(entries)
+	require.NoError(t, err)
+	var got []string
+	for _, entry := range all {
+		got = append(got, string(entry.Payload))
+	}
+	return got
+}
+
+func TestShouldConsumeEntriesAfterOffset(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	appended := client.Append(storeID, "space", "segment", record("a"), record("b"), record("c"))
+	args := &streamkit.Consume{Offsets: map[string]lexkey.LexKey{"space": appended[0].GetSpaceOffset()}}
+
+	// Act
+	got := payloads(t, client.Consume(context.Background(), storeID, args))
+
+	// Assert
+	assert.Equal(t, []string{"b", "c"}, got)
+}
+
+func TestShouldConsumeSpacesFromStartAndIsolateStores(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	client.Append(storeID, "b", "segment", record("b1"))
+	client.Append(storeID, "a", "segment", record("a1"))
+	client.Append(uuid.New(), "a", "segment", record("other"))
+	args := &streamkit.Consume{Offsets: map[string]lexkey.LexKey{"a": lexkey.Empty, "b": lexkey.Empty}}
+
+	// Act
+	got := payloads(t, client.Consume(context.Background(), storeID, args))
+
+	// Assert
+	assert.Equal(t, []string{"a1", "b1"}, got)
+}
+
+func TestShouldNumberSequencesPerSegment(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	client.Append(storeID, "space", "one", record("a"))
+
+	// Act
+	entries := client.Append(storeID, "space", "two", record("b"), record("c"))
+
+	// Assert
+	assert.Equal(t, uint64(1), entries[0].Sequence)
+	assert.Equal(t, uint64(2), entries[1].Sequence)
+	assert.Len(t, client.Entries(storeID, "space"), 3)
+}
+
+func TestShouldConsumeSegmentWithinSequenceRange(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	client.Append(storeID, "space", "one", record("a"), record("b"), record("c"), record("d"))
+	client.Append(storeID, "space", "two", record("other"))
+	args := &streamkit.ConsumeSegment{Space: "space", Segment: "one", MinSequence: 2, MaxSequence: 3}
+
+	// Act
+	got := payloads(t, client.ConsumeSegment(context.Background(), storeID, args))
+	tail := payloads(t, client.ConsumeSegment(context.Background(), storeID, &streamkit.ConsumeSegment{Space: "space", Segment: "one", MinSequence: 4}))
+
+	// Assert
+	assert.Equal(t, []string{"b", "c"}, got)
+	assert.Equal(t, []string{"d"}, tail)
+}
+
+func TestShouldPeekLastEntryOfSegment(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	client.Append(storeID, "space", "one", record("a"), record("b"))
+	client.Append(storeID, "space", "two", record("c"))
+
+	// Act
+	last, err := client.Peek(context.Background(), storeID, "space", "one")
+	require.NoError(t, err)
+	empty, emptyErr := client.Peek(context.Background(), storeID, "space", "missing")
+
+	// Assert
+	assert.Equal(t, "b", string(last.Payload))
+	assert.Equal(t, uint64(2), last.Sequence)
+	assert.NoError(t, emptyErr)
+	assert.Nil(t, empty)
+}
+
+func TestShouldNotifySubscribersOfAppends(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	var statuses []*streamkit.SegmentStatus
+	sub, err := client.SubscribeToSpace(context.Background(), storeID, "space", func(status *streamkit.SegmentStatus) {
+		statuses = append(statuses, status)
+	})
+	require.NoError(t, err)
+
+	// Act
+	client.Append(storeID, "space", "segment", record("a"), record("b"))
+	client.Append(storeID, "other", "segment", record("c"))
+	sub.Unsubscribe()
+	client.Append(storeID, "space", "segment", record("d"))
+
+	// Assert
+	assert.Equal(t, []*streamkit.SegmentStatus{
+		{Space: "space", Segment: "segment", FirstSequence: 1, LastSequence: 2},
+	}, statuses)
+}
+
+func TestShouldStopNotifyingWhenContextEnds(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	notified := make(chan struct{}, 1)
+	_, err := client.SubscribeToSpace(ctx, storeID, "space", func(*streamkit.SegmentStatus) {
+		notified <- struct{}{}
+	})
+	require.NoError(t, err)
+
+	// Act
+	cancel()
+	assert.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.subs[spaceKey{storeID, "space"}]) == 0
+	}, time.Second, time.Millisecond)
+	client.Append(storeID, "space", "segment", record("a"))
+
+	// Assert
+	assert.Empty(t, notified)
+}
+
+func TestShouldRejectProducedRecordsOutOfSequence(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	ctx := context.Background()
+	first := []*streamkit.Record{{Sequence: 1, Payload: []byte("a")}, {Sequence: 2, Payload: []byte("b")}}
+	require.NoError(t, enumerators.Consume(client.Produce(ctx, storeID, "space", "segment", enumerators.Slice(first))))
+
+	// Act
+	retry := []*streamkit.Record{{Sequence: 2, Payload: []byte("b")}}
+	err := enumerators.Consume(client.Produce(ctx, storeID, "space", "segment", enumerators.Slice(retry)))
+
+	// Assert
+	assert.ErrorIs(t, err, ErrSequenceConflict)
+	assert.Len(t, client.Entries(storeID, "space"), 2)
+}
+
+func TestShouldFailConsumeUntilCleared(t *testing.T) {
+	// Arrange
+	client := New()
+	storeID := uuid.New()
+	client.Append(storeID, "space", "segment", record("a"))
+	args := &streamkit.Consume{Offsets: map[string]lexkey.LexKey{"space": lexkey.Empty}}
+	boom := errors.New("boom")
+	client.FailConsume(boom)
+
+	// Act
+	failed := enumerators.Consume(client.Consume(context.Background(), storeID, args))
+	client.FailConsume(nil)
+	got := payloads(t, client.Consume(context.Background(), storeID, args))
+
+	// Assert
+	assert.ErrorIs(t, failed, boom)
+	assert.Equal(t, []string{"a"}, got)
+}
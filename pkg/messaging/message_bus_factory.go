@@ -15,9 +15,10 @@ import (
 	"time"
 
 	"github.com/fgrzl/messaging"
-	"github.com/fgrzl/messaging/pkg/natsbus"
 	"github.com/google/uuid"
 	"github.com/hydn-co/mesh-sdk/pkg/auth/creds"
+	"github.com/hydn-co/mesh-sdk/pkg/breaker"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nkeys"
 )
 
@@ -39,6 +40,10 @@ type MessageBusOptions struct {
 	AuthBaseDelay time.Duration
 	// Maximum number of bytes to read from auth response (default 64KB)
 	MaxAuthRespBytes int64
+	// Optional circuit breaker for the auth endpoint. While it is open, Get
+	// fails fast with an error wrapping breaker.ErrCircuitOpen instead of
+	// retrying. Network errors and 5xx responses count as failures.
+	AuthBreaker *breaker.Breaker
 }
 
 var rng *rand.Rand
@@ -62,6 +67,7 @@ func NewMessageBusFactory(opts MessageBusOptions) messaging.MessageBusFactory {
 		authAttempts:     opts.AuthAttempts,
 		authBaseDelay:    opts.AuthBaseDelay,
 		maxAuthRespBytes: opts.MaxAuthRespBytes,
+		authBreaker:      opts.AuthBreaker,
 	}
 }
 
@@ -76,12 +82,16 @@ type DefaultMessageBusFactory struct {
 	authAttempts     int
 	authBaseDelay    time.Duration
 	maxAuthRespBytes int64
+	authBreaker      *breaker.Breaker
 
 	mu  sync.Mutex
 	bus messaging.MessageBus
 }
 
-// Get returns a cached or newly established message bus connection.
+// Get returns a cached or newly established message bus connection. The bus
+// implements ConnectionStateNotifier so request handler monitors can recover
+// subscriptions on reconnect instead of refreshing periodically, implements
+// QueueSubscriber, and sends carry the trace context of the caller's context.
 func (f *DefaultMessageBusFactory) Get(ctx context.Context) (messaging.MessageBus, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -102,13 +112,16 @@ func (f *DefaultMessageBusFactory) Get(ctx context.Context) (messaging.MessageBu
 			return nil, ctx.Err()
 		}
 		slog.Debug("attempting to create message bus", "attempt", attempt)
-		bus, err := natsbus.NewBus(f.brokerURL, f.fetchJWT(ctx), f.signNonce)
+		bus, err := dialNatsBus(f.brokerURL, nats.UserJWT(f.fetchJWT(ctx), f.signNonce))
 		if err == nil {
 			f.bus = bus
 			return bus, nil
 		}
 		lastErr = err
 		slog.Warn("message bus creation attempt failed", "attempt", attempt, "err", err)
+		if errors.Is(err, breaker.ErrCircuitOpen) {
+			return nil, fmt.Errorf("create message bus: %w", err)
+		}
 		if attempt == maxAttempts {
 			slog.Error("failed to create message bus after retries", "broker_url", f.brokerURL, "err", err)
 			return nil, fmt.Errorf("create message bus: %w", err)
@@ -191,8 +204,14 @@ func (f *DefaultMessageBusFactory) fetchJWT(ctx context.Context) func() (string,
 			}
 			req.Header.Set("Content-Type", "application/json")
 
+			if f.authBreaker != nil {
+				if err := f.authBreaker.Allow(); err != nil {
+					return "", fmt.Errorf("auth request: %w", err)
+				}
+			}
 			resp, err := client.Do(req)
 			if err != nil {
+				f.recordAuth(err)
 				slog.Warn("auth request attempt failed", "attempt", a, "err", err)
 				if a == maxAuthAttempts {
 					slog.Error("auth request failed after retries", "auth_url", f.authURL, "err", err)
@@ -219,15 +238,23 @@ func (f *DefaultMessageBusFactory) fetchJWT(ctx context.Context) func() (string,
 					trimmed = trimmed[:200] + "..."
 				}
 				slog.Error("auth endpoint returned non-200", "status", resp.StatusCode, "body", trimmed)
-				return "", fmt.Errorf("auth failed: %s", trimmed)
+				err := fmt.Errorf("auth failed: %s", trimmed)
+				if resp.StatusCode >= http.StatusInternalServerError {
+					f.recordAuth(err)
+				} else {
+					// the endpoint is up; the credentials were rejected
+					f.recordAuth(nil)
+				}
+				return "", err
 			}
 
 			token := strings.TrimSpace(string(bodyBytes))
 			slog.Debug("received auth token for message bus", "len", len(token))
 			if token == "" {
 				// treat empty token as transient error so we can retry
+				f.recordAuth(errEmptyToken)
 				if a == maxAuthAttempts {
-					return "", errors.New("empty token from auth endpoint")
+					return "", errEmptyToken
 				}
 				backoff := authBaseDelay * time.Duration(1<<(a-1))
 				jitter := time.Duration(rng.Int63n(int64(backoff / 2)))
@@ -239,6 +266,7 @@ func (f *DefaultMessageBusFactory) fetchJWT(ctx context.Context) func() (string,
 				}
 				continue
 			}
+			f.recordAuth(nil)
 			return token, nil
 		}
 
@@ -246,6 +274,15 @@ func (f *DefaultMessageBusFactory) fetchJWT(ctx context.Context) func() (string,
 	}
 }
 
+var errEmptyToken = errors.New("empty token from auth endpoint")
+
+// recordAuth reports the outcome of an auth attempt to the auth breaker.
+func (f *DefaultMessageBusFactory) recordAuth(err error) {
+	if f.authBreaker != nil {
+		f.authBreaker.Record(err)
+	}
+}
+
 func (f *DefaultMessageBusFactory) signNonce(nonce []byte) ([]byte, error) {
 	sig, err := f.user.Sign(nonce)
 	if err != nil {
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/breaker"
 	"github.com/nats-io/nkeys"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -132,3 +133,34 @@ func TestFetchJWTEmptyTokenRetriesThenError(t *testing.T) {
 	assert.Equal(t, "", tok)
 	assert.Equal(t, 3, tr.called)
 }
+
+func TestFetchJWTFailsFastWhileAuthBreakerIsOpen(t *testing.T) {
+	// Arrange
+	f := buildFactoryForTest(t)
+	tr := &testRoundTripper{failsBefore: 10}
+	f.httpClient = &http.Client{Transport: tr}
+	f.authAttempts = 3
+	f.authBaseDelay = time.Millisecond
+	f.authBreaker = breaker.New(breaker.WithFailureThreshold(2), breaker.WithCooldown(time.Hour))
+
+	// Act
+	_, err := f.fetchJWT(context.Background())()
+
+	// Assert
+	require.ErrorIs(t, err, breaker.ErrCircuitOpen)
+	assert.Equal(t, 2, tr.called)
+}
+
+func TestFetchJWTDoesNotTripAuthBreakerOnRejectedCredentials(t *testing.T) {
+	// Arrange
+	f := buildFactoryForTest(t)
+	f.httpClient = &http.Client{Transport: &testRoundTripper{respBody: "bad credentials", respStatus: http.StatusUnauthorized}}
+	f.authBreaker = breaker.New(breaker.WithFailureThreshold(1))
+
+	// Act
+	_, err := f.fetchJWT(context.Background())()
+
+	// Assert
+	require.Error(t, err)
+	assert.Equal(t, breaker.Closed, f.authBreaker.State())
+}
@@ -0,0 +1,92 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/messaging"
+)
+
+// HandlerKind identifies whether a middleware is wrapping a request handler
+// or a fire-and-forget message handler.
+type HandlerKind int
+
+const (
+	RequestHandlerKind HandlerKind = iota
+	MessageHandlerKind
+)
+
+func (k HandlerKind) String() string {
+	switch k {
+	case RequestHandlerKind:
+		return "request"
+	case MessageHandlerKind:
+		return "message"
+	default:
+		return fmt.Sprintf("HandlerKind(%d)", int(k))
+	}
+}
+
+// HandlerInfo describes the handler a middleware is wrapping.
+type HandlerInfo struct {
+	Route messaging.Route
+	Kind  HandlerKind
+}
+
+// Handler is the uniform handler shape middleware operates on. Request and
+// message handlers are both adapted to it; message handlers always return a
+// nil Response.
+type Handler func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error)
+
+// Middleware decorates a Handler with cross-cutting behavior. It is invoked
+// once per registration with the HandlerInfo of the handler being registered,
+// so per-route state (span names, metric attributes) can be computed up front.
+type Middleware func(info HandlerInfo, next Handler) Handler
+
+// chainMiddleware applies mws to h so that mws[0] is the outermost layer.
+func chainMiddleware(info HandlerInfo, h Handler, mws []Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](info, h)
+		}
+	}
+	return h
+}
+
+// wrapRequestHandler adapts handler to the middleware pipeline and back.
+func wrapRequestHandler(route messaging.Route, handler messaging.RequestHandler, mws []Middleware) messaging.RequestHandler {
+	if len(mws) == 0 {
+		return handler
+	}
+	info := HandlerInfo{Route: route, Kind: RequestHandlerKind}
+	h := chainMiddleware(info, func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+		req, ok := msg.(messaging.Request)
+		if !ok {
+			return nil, fmt.Errorf("unexpected request type: got %T", msg)
+		}
+		return handler(ctx, req)
+	}, mws)
+	return func(ctx context.Context, req messaging.Request) (messaging.Response, error) {
+		return h(ctx, req)
+	}
+}
+
+// wrapMessageHandler adapts handler to the middleware pipeline and back.
+func wrapMessageHandler(route messaging.Route, handler messaging.MessageHandler, mws []Middleware) messaging.MessageHandler {
+	if len(mws) == 0 {
+		return handler
+	}
+	info := HandlerInfo{Route: route, Kind: MessageHandlerKind}
+	h := chainMiddleware(info, func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+		m, ok := msg.(messaging.Message)
+		if !ok {
+			return nil, fmt.Errorf("unexpected message type: got %T", msg)
+		}
+		return nil, handler(ctx, m)
+	}, mws)
+	return func(ctx context.Context, msg messaging.Message) error {
+		_, err := h(ctx, msg)
+		return err
+	}
+}
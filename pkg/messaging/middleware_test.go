@@ -0,0 +1,171 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/claims"
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/meshctx"
+	"github.com/hydn-co/mesh-sdk/pkg/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testRequest implements messaging.Request for middleware tests.
+type testRequest struct {
+	TenantID uuid.UUID `json:"tenant_id"`
+}
+
+func (*testRequest) GetDiscriminator() string { return "mesh://test/request" }
+
+func (r *testRequest) GetRoute() messaging.Route {
+	return messaging.NewTenantRoute("test", "request", &r.TenantID)
+}
+
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(info HandlerInfo, next Handler) Handler {
+		return func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+			*calls = append(*calls, name)
+			return next(ctx, msg)
+		}
+	}
+}
+
+func TestShouldApplyMiddlewareOutermostFirst(t *testing.T) {
+	// Arrange
+	var calls []string
+	req := &testRequest{TenantID: uuid.New()}
+	handler := wrapRequestHandler(req.GetRoute(), func(ctx context.Context, r messaging.Request) (messaging.Response, error) {
+		calls = append(calls, "handler")
+		return &messaging.Accepted{}, nil
+	}, []Middleware{recordingMiddleware("first", &calls), recordingMiddleware("second", &calls)})
+
+	// Act
+	resp, err := handler(context.Background(), req)
+
+	// Assert
+	require.NoError(t, err)
+	assert.IsType(t, &messaging.Accepted{}, resp)
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
+
+func TestShouldRecoverFromHandlerPanic(t *testing.T) {
+	// Arrange
+	req := &testRequest{}
+	handler := wrapRequestHandler(req.GetRoute(), func(ctx context.Context, r messaging.Request) (messaging.Response, error) {
+		panic("boom")
+	}, []Middleware{RecoveryMiddleware()})
+
+	// Act
+	resp, err := handler(context.Background(), req)
+
+	// Assert
+	assert.Nil(t, resp)
+	assert.ErrorIs(t, err, ErrHandlerPanic)
+}
+
+func TestShouldReturnTimeoutErrorWhenHandlerExceedsDeadline(t *testing.T) {
+	// Arrange
+	req := &testRequest{}
+	handler := wrapRequestHandler(req.GetRoute(), func(ctx context.Context, r messaging.Request) (messaging.Response, error) {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		return &messaging.Accepted{}, nil
+	}, []Middleware{TimeoutMiddleware(10 * time.Millisecond)})
+
+	// Act
+	_, err := handler(context.Background(), req)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrHandlerTimeout)
+}
+
+func TestShouldStoreTenantFromRouteInContext(t *testing.T) {
+	// Arrange
+	tenantID := uuid.New()
+	req := &testRequest{TenantID: tenantID}
+	var got uuid.UUID
+	handler := wrapRequestHandler(req.GetRoute(), func(ctx context.Context, r messaging.Request) (messaging.Response, error) {
+		got, _ = meshctx.TenantIDFromContext(ctx)
+		return &messaging.Accepted{}, nil
+	}, []Middleware{TenantMiddleware()})
+
+	// Act
+	_, err := handler(context.Background(), req)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, tenantID, got)
+}
+
+func TestShouldRejectUnauthenticatedInvocation(t *testing.T) {
+	// Arrange
+	req := &testRequest{}
+	called := false
+	handler := wrapRequestHandler(req.GetRoute(), func(ctx context.Context, r messaging.Request) (messaging.Response, error) {
+		called = true
+		return &messaging.Accepted{}, nil
+	}, []Middleware{AuthorizationMiddleware(nil)})
+
+	// Act
+	resp, err := handler(context.Background(), req)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.Nil(t, resp)
+	assert.False(t, called)
+}
+
+func TestShouldPassPrincipalToAuthorizer(t *testing.T) {
+	// Arrange
+	req := &testRequest{}
+	principal := claims.NewPrincipal(claims.NewClaimsSet(uuid.NewString()))
+	ctx := claims.WithUser(context.Background(), principal)
+	denied := errors.New("denied")
+	handler := wrapRequestHandler(req.GetRoute(), func(ctx context.Context, r messaging.Request) (messaging.Response, error) {
+		return &messaging.Accepted{}, nil
+	}, []Middleware{AuthorizationMiddleware(func(ctx context.Context, p claims.Principal, msg polymorphic.Polymorphic) error {
+		assert.Equal(t, principal.Subject(), p.Subject())
+		return denied
+	})})
+
+	// Act
+	_, err := handler(ctx, req)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.ErrorIs(t, err, denied)
+}
+
+func TestShouldWrapRegisteredHandlersWithProcessorMiddleware(t *testing.T) {
+	// Arrange
+	var calls []string
+	bus := testkit.NewMockMessageBus()
+	var registered messaging.RequestHandler
+	bus.Mock.On("SubscribeRequest", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { registered = args.Get(1).(messaging.RequestHandler) }).
+		Return(&mockSubscription{id: uuid.New()}, nil).Once()
+	p := NewMessageBusProcessorBase(bus, WithMiddleware(recordingMiddleware("processor", &calls)))
+	req := &testRequest{}
+
+	_, err := p.RegisterRequestHandler(req.GetRoute(), func(ctx context.Context, r messaging.Request) (messaging.Response, error) {
+		calls = append(calls, "handler")
+		return &messaging.Accepted{}, nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, registered)
+
+	// Act
+	_, err = registered(context.Background(), req)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"processor", "handler"}, calls)
+}
@@ -0,0 +1,267 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/fgrzl/claims"
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/meshctx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/hydn-co/mesh-sdk/pkg/messaging"
+
+var (
+	// ErrHandlerPanic is wrapped by errors returned from RecoveryMiddleware
+	// when a handler panics.
+	ErrHandlerPanic = errors.New("handler panic")
+	// ErrHandlerTimeout is wrapped by errors returned from TimeoutMiddleware
+	// when a handler does not complete in time.
+	ErrHandlerTimeout = errors.New("handler timed out")
+	// ErrUnauthenticated indicates a handler requiring a principal was invoked
+	// without one in context.
+	ErrUnauthenticated = errors.New("unauthenticated")
+)
+
+// LoggingMiddleware logs every handler invocation with its route, kind,
+// discriminator and duration. Failures are logged at Warn, successes at Debug.
+// A nil logger uses slog.Default().
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(info HandlerInfo, next Handler) Handler {
+		return func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+			log := logger
+			if log == nil {
+				log = slog.Default()
+			}
+			start := time.Now()
+			resp, err := next(ctx, msg)
+			attrs := []any{
+				"route", info.Route.String(),
+				"kind", info.Kind.String(),
+				"discriminator", msg.GetDiscriminator(),
+				"duration_ms", time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				log.WarnContext(ctx, "handler failed", append(attrs, "error", err)...)
+				return resp, err
+			}
+			log.DebugContext(ctx, "handler completed", attrs...)
+			return resp, nil
+		}
+	}
+}
+
+// RecoveryMiddleware converts handler panics into errors wrapping
+// ErrHandlerPanic and logs the stack trace, so a single bad message cannot
+// take down the subscription goroutine.
+func RecoveryMiddleware() Middleware {
+	return func(info HandlerInfo, next Handler) Handler {
+		return func(ctx context.Context, msg polymorphic.Polymorphic) (resp messaging.Response, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					slog.ErrorContext(ctx, "recovered handler panic",
+						"route", info.Route.String(),
+						"kind", info.Kind.String(),
+						"panic", r,
+						"stack", string(debug.Stack()))
+					resp = nil
+					err = fmt.Errorf("%w on %s: %v", ErrHandlerPanic, info.Route.String(), r)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// TimeoutMiddleware bounds each invocation by d. The handler receives a
+// context with the deadline applied; if it does not return before the deadline
+// the middleware returns an error wrapping ErrHandlerTimeout without waiting
+// for it. The abandoned handler still counts as in flight, so processor Drain
+// and Shutdown wait for it; handlers should honor ctx so it stops promptly.
+func TimeoutMiddleware(d time.Duration) Middleware {
+	return func(info HandlerInfo, next Handler) Handler {
+		if d <= 0 {
+			return next
+		}
+		return func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			type result struct {
+				resp messaging.Response
+				err  error
+			}
+			done := make(chan result, 1)
+			release := retainInflight(ctx)
+			go func() {
+				defer release()
+				resp, err := next(ctx, msg)
+				done <- result{resp: resp, err: err}
+			}()
+
+			select {
+			case r := <-done:
+				return r.resp, r.err
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%w after %s on %s", ErrHandlerTimeout, d, info.Route.String())
+			}
+		}
+	}
+}
+
+// TenantMiddleware stores the tenant ID of the incoming message in the
+// context using meshctx.WithTenantID. The tenant is taken from the message's
+// tenant-scoped route, falling back to the tenant carried by the messaging
+// context. Contexts that already hold a meshctx tenant are left untouched.
+func TenantMiddleware() Middleware {
+	return func(info HandlerInfo, next Handler) Handler {
+		return func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+			if _, err := meshctx.TenantIDFromContext(ctx); err != nil {
+				if tenantID, ok := tenantFromMessage(ctx, msg); ok {
+					ctx = meshctx.WithTenantID(ctx, tenantID)
+				}
+			}
+			return next(ctx, msg)
+		}
+	}
+}
+
+// AuthorizationMiddleware requires a claims.Principal in context and checks it
+// with authorize. Rejected invocations fail with an error wrapping
+// ErrForbidden, which the bus returns to requesters as a
+// messaging.ErrorResponse; every denial is logged at Warn with the route and
+// subject.
+func AuthorizationMiddleware(authorize Authorizer) Middleware {
+	return func(info HandlerInfo, next Handler) Handler {
+		return func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+			principal, ok := claims.UserFromContext(ctx)
+			var err error
+			if !ok {
+				err = fmt.Errorf("%w: %w", ErrForbidden, ErrUnauthenticated)
+			} else if authorize != nil {
+				err = authorize(ctx, principal, msg)
+			}
+			if err == nil {
+				return next(ctx, msg)
+			}
+
+			subject := ""
+			if ok {
+				subject = principal.Subject()
+			}
+			slog.WarnContext(ctx, "handler authorization denied",
+				"route", info.Route.String(),
+				"kind", info.Kind.String(),
+				"discriminator", msg.GetDiscriminator(),
+				"subject", subject,
+				"reason", err.Error())
+
+			if !errors.Is(err, ErrForbidden) {
+				err = fmt.Errorf("%w: %w", ErrForbidden, err)
+			}
+			return nil, err
+		}
+	}
+}
+
+// MetricsMiddleware records an invocation counter and a duration histogram
+// per handler, tagged with route, kind and outcome. A nil meter uses the
+// global OpenTelemetry meter provider.
+func MetricsMiddleware(meter metric.Meter) Middleware {
+	if meter == nil {
+		meter = otel.Meter(instrumentationName)
+	}
+	calls, callsErr := meter.Int64Counter("mesh.messaging.handler.calls",
+		metric.WithDescription("Number of message bus handler invocations."))
+	duration, durationErr := meter.Float64Histogram("mesh.messaging.handler.duration",
+		metric.WithDescription("Duration of message bus handler invocations."),
+		metric.WithUnit("s"))
+	if err := errors.Join(callsErr, durationErr); err != nil {
+		slog.Warn("failed to create handler metrics instruments", "error", err)
+	}
+
+	return func(info HandlerInfo, next Handler) Handler {
+		base := []attribute.KeyValue{
+			attribute.String("messaging.route", info.Route.String()),
+			attribute.String("messaging.handler.kind", info.Kind.String()),
+		}
+		return func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+			start := time.Now()
+			resp, err := next(ctx, msg)
+			outcome := "success"
+			if err != nil {
+				outcome = "error"
+			}
+			attrs := metric.WithAttributes(append(base, attribute.String("outcome", outcome))...)
+			if calls != nil {
+				calls.Add(ctx, 1, attrs)
+			}
+			if duration != nil {
+				duration.Record(ctx, time.Since(start).Seconds(), attrs)
+			}
+			return resp, err
+		}
+	}
+}
+
+// TracingMiddleware starts a span around every invocation, named after the
+// handler kind and route. Errors are recorded on the span. A nil tracer uses
+// the global OpenTelemetry tracer provider. Processors already run every
+// handler in such a span from the global provider; the middleware adds one
+// from tracer inside the pipeline.
+func TracingMiddleware(tracer trace.Tracer) Middleware {
+	if tracer == nil {
+		tracer = otel.Tracer(instrumentationName)
+	}
+	return func(info HandlerInfo, next Handler) Handler {
+		return func(ctx context.Context, msg polymorphic.Polymorphic) (messaging.Response, error) {
+			ctx, span := startHandlerSpan(ctx, tracer, info, msg.GetDiscriminator())
+			defer span.End()
+
+			resp, err := next(ctx, msg)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return resp, err
+		}
+	}
+}
+
+// startHandlerSpan starts the span of one handler invocation: a consumer span
+// for messages and a server span for requests.
+func startHandlerSpan(ctx context.Context, tracer trace.Tracer, info HandlerInfo, discriminator string) (context.Context, trace.Span) {
+	kind := trace.SpanKindServer
+	if info.Kind == MessageHandlerKind {
+		kind = trace.SpanKindConsumer
+	}
+	return tracer.Start(ctx, info.Kind.String()+" "+info.Route.String(),
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			attribute.String("messaging.route", info.Route.String()),
+			attribute.String("messaging.discriminator", discriminator),
+		))
+}
+
+// tenantFromMessage resolves the tenant a message is addressed to, falling
+// back to the tenant carried by the messaging context.
+func tenantFromMessage(ctx context.Context, msg polymorphic.Polymorphic) (uuid.UUID, bool) {
+	if tenantID, ok := requestTenant(msg); ok {
+		return tenantID, true
+	}
+	if tenantID := messaging.GetTenantID(ctx); tenantID != uuid.Nil {
+		return tenantID, true
+	}
+	return uuid.Nil, false
+}
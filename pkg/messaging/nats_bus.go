@@ -0,0 +1,289 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fgrzl/claims"
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/messaging"
+	"github.com/fgrzl/messaging/pkg/natsbus"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/tracing"
+	"github.com/nats-io/nats.go"
+)
+
+// Headers sent with every NATS message. They match the natsbus package of
+// the messaging module, so services on either bus understand each other.
+// Trace context travels in the W3C traceparent and tracestate headers.
+const (
+	correlationIDHeader = "X-Correlation-ID"
+	causationIDHeader   = "X-Causation-ID"
+	userPrincipalHeader = "X-User-Principal"
+)
+
+var (
+	_ messaging.MessageBus    = (*natsBus)(nil)
+	_ ConnectionStateNotifier = (*natsBus)(nil)
+	_ QueueSubscriber         = (*natsBus)(nil)
+)
+
+// QueueSubscriber is implemented by message buses that can share the
+// messages of a route among the members of a queue group, including buses
+// returned by NewMessageBusFactory.
+type QueueSubscriber interface {
+	SubscribeWithOptions(route messaging.Route, handler messaging.MessageHandler, opts messaging.SubscriptionOpts) (messaging.Subscription, error)
+}
+
+// natsBus is the message bus created by DefaultMessageBusFactory. It speaks
+// the wire format of natsbus and owns its NATS connection, so it can report
+// connection state. The NATS client restores subscriptions after a
+// reconnect.
+type natsBus struct {
+	conn *nats.Conn
+
+	mu       sync.Mutex
+	nextID   uint64
+	handlers map[uint64]func(ConnectionState)
+}
+
+// dialNatsBus connects to url. opts, such as credentials, are applied after
+// the bus's reconnect policy and may override it.
+func dialNatsBus(url string, opts ...nats.Option) (*natsBus, error) {
+	b := &natsBus{handlers: make(map[uint64]func(ConnectionState))}
+	defaults := []nats.Option{
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2 * time.Second),
+		nats.PingInterval(20 * time.Second),
+		nats.DisconnectErrHandler(func(c *nats.Conn, err error) {
+			slog.Warn("disconnected from NATS", "error", err)
+			b.notify(ConnectionDisconnected)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			slog.Info("reconnected to NATS", "server", c.ConnectedUrl())
+			b.notify(ConnectionReconnected)
+		}),
+		nats.ClosedHandler(func(*nats.Conn) {
+			b.notify(ConnectionClosed)
+		}),
+	}
+	conn, err := nats.Connect(url, append(defaults, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	return b, nil
+}
+
+func (b *natsBus) Notify(msg messaging.Message) error {
+	return b.NotifyWithContext(context.Background(), msg)
+}
+
+func (b *natsBus) NotifyWithContext(ctx context.Context, msg messaging.Message) error {
+	out, err := newNatsMsg(ctx, msg)
+	if err != nil {
+		return err
+	}
+	return b.conn.PublishMsg(out)
+}
+
+func (b *natsBus) Request(msg messaging.Request, timeout time.Duration) (messaging.Response, error) {
+	return b.RequestWithContext(context.Background(), msg, timeout)
+}
+
+// RequestWithContext sends msg and waits for the reply until timeout passes
+// or ctx is done. A reply that does not arrive within timeout fails with
+// context.DeadlineExceeded.
+func (b *natsBus) RequestWithContext(ctx context.Context, msg messaging.Request, timeout time.Duration) (messaging.Response, error) {
+	out, err := newNatsMsg(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	reply, err := b.conn.RequestMsgWithContext(ctx, out)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s: %w", out.Subject, err)
+	}
+	return decodeNatsMsg[messaging.Response](reply)
+}
+
+func (b *natsBus) Subscribe(route messaging.Route, handler messaging.MessageHandler) (messaging.Subscription, error) {
+	return b.SubscribeWithOptions(route, handler, messaging.SubscriptionOpts{})
+}
+
+// SubscribeWithOptions subscribes handler to route. When opts names a queue
+// group, each message is delivered to one member of the group.
+func (b *natsBus) SubscribeWithOptions(route messaging.Route, handler messaging.MessageHandler, opts messaging.SubscriptionOpts) (messaging.Subscription, error) {
+	subject := natsSubject(route)
+	cb := func(m *nats.Msg) { handleNatsMessage(m, handler) }
+	var sub *nats.Subscription
+	var err error
+	if opts.QueueGroup != "" {
+		sub, err = b.conn.QueueSubscribe(subject, opts.QueueGroup, cb)
+	} else {
+		sub, err = b.conn.Subscribe(subject, cb)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to %s: %w", subject, err)
+	}
+	return natsbus.NewSubscription(sub), nil
+}
+
+func (b *natsBus) SubscribeRequest(route messaging.Route, handler messaging.RequestHandler) (messaging.Subscription, error) {
+	subject := natsSubject(route)
+	sub, err := b.conn.Subscribe(subject, func(m *nats.Msg) { handleNatsRequest(m, handler) })
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to requests on %s: %w", subject, err)
+	}
+	return natsbus.NewSubscription(sub), nil
+}
+
+// Close closes the connection, which ends every subscription.
+func (b *natsBus) Close() error {
+	b.conn.Close()
+	return nil
+}
+
+// OnConnectionStateChange calls handler on the NATS client's callback
+// goroutine whenever the connection drops, recovers or closes.
+func (b *natsBus) OnConnectionStateChange(handler func(ConnectionState)) (unregister func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+func (b *natsBus) notify(state ConnectionState) {
+	b.mu.Lock()
+	handlers := make([]func(ConnectionState), 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.Unlock()
+
+	for _, h := range handlers {
+		h(state)
+	}
+}
+
+func handleNatsMessage(m *nats.Msg, handler messaging.MessageHandler) {
+	ctx := natsContext(m.Header)
+	msg, err := decodeNatsMsg[messaging.Message](m)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to deserialize message", "subject", m.Subject, "error", err)
+		return
+	}
+	if err := handler(ctx, msg); err != nil {
+		slog.WarnContext(ctx, "message handler error", "subject", m.Subject, "error", err)
+	}
+}
+
+// handleNatsRequest runs handler and replies with its response. Failures
+// are answered with a messaging.ErrorResponse.
+func handleNatsRequest(m *nats.Msg, handler messaging.RequestHandler) {
+	ctx := natsContext(m.Header)
+	var resp messaging.Response
+	req, err := decodeNatsMsg[messaging.Request](m)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to deserialize request", "subject", m.Subject, "error", err)
+		resp = &messaging.ErrorResponse{Error: "Invalid request format"}
+	} else if resp, err = handler(ctx, req); err != nil {
+		slog.WarnContext(ctx, "request handler error", "subject", m.Subject, "error", err)
+		resp = &messaging.ErrorResponse{Error: err.Error()}
+	}
+
+	reply, err := polymorphic.MarshalPolymorphicJSON(resp)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to serialize response", "subject", m.Subject, "error", err)
+		reply, _ = polymorphic.MarshalPolymorphicJSON(&messaging.ErrorResponse{Error: err.Error()})
+	}
+	if err := m.Respond(reply); err != nil {
+		slog.WarnContext(ctx, "failed to send response", "subject", m.Subject, "error", err)
+	}
+}
+
+// newNatsMsg encodes msg, or a messaging.Request, for its route.
+func newNatsMsg(ctx context.Context, msg messaging.Message) (*nats.Msg, error) {
+	subject := natsSubject(msg.GetRoute())
+	data, err := polymorphic.MarshalPolymorphicJSON(msg)
+	if err != nil {
+		return nil, fmt.Errorf("serialize %s for %s: %w", msg.GetDiscriminator(), subject, err)
+	}
+	return &nats.Msg{Subject: subject, Data: data, Header: natsHeader(ctx)}, nil
+}
+
+func decodeNatsMsg[T polymorphic.Polymorphic](m *nats.Msg) (T, error) {
+	var zero T
+	envelope, err := polymorphic.UnmarshalPolymorphicJSON(m.Data)
+	if err != nil {
+		return zero, err
+	}
+	content, ok := envelope.Content.(T)
+	if !ok {
+		return zero, fmt.Errorf("unexpected discriminator %q", envelope.Discriminator)
+	}
+	return content, nil
+}
+
+// natsSubject returns the subject route resolves to. Tenant and inbox routes
+// without an ID resolve to a wildcard matching every ID.
+func natsSubject(route messaging.Route) string {
+	if route.Scope == messaging.ScopeTenant || route.Scope == messaging.ScopeInbox {
+		id := "*"
+		if route.ID != nil {
+			id = route.ID.String()
+		}
+		return fmt.Sprintf("%s.%s.%s.%s", route.Scope, id, route.Area, route.Name)
+	}
+	return fmt.Sprintf("%s.%s.%s", route.Scope, route.Area, route.Name)
+}
+
+// natsHeader returns the headers that carry ctx's tracing IDs, user
+// principal and span context.
+func natsHeader(ctx context.Context) nats.Header {
+	h := nats.Header{}
+	correlationID, causationID := messaging.GetTracing(ctx)
+	if correlationID != uuid.Nil {
+		h.Set(correlationIDHeader, correlationID.String())
+	}
+	if causationID != uuid.Nil {
+		h.Set(causationIDHeader, causationID.String())
+	}
+	if user, ok := messaging.GetUserPrincipal(ctx); ok {
+		if serialized, err := claims.SerializePrincipal(user); err == nil {
+			h.Set(userPrincipalHeader, serialized)
+		} else {
+			slog.WarnContext(ctx, "failed to serialize user principal", "error", err)
+		}
+	}
+	tracing.InjectHeader(ctx, h)
+	return h
+}
+
+// natsContext returns a context holding what natsHeader put in h.
+func natsContext(h nats.Header) context.Context {
+	ctx := context.Background()
+	correlationID, _ := uuid.Parse(h.Get(correlationIDHeader))
+	causationID, _ := uuid.Parse(h.Get(causationIDHeader))
+	if correlationID != uuid.Nil || causationID != uuid.Nil {
+		ctx = messaging.ContextWithTracing(ctx, correlationID, causationID)
+	}
+	if serialized := h.Get(userPrincipalHeader); serialized != "" {
+		if user, err := claims.DeserializePrincipal(serialized); err == nil {
+			ctx = messaging.ContextWithUserPrincipal(ctx, user)
+		} else {
+			slog.WarnContext(ctx, "failed to deserialize user principal", "error", err)
+		}
+	}
+	return tracing.ExtractHeader(ctx, h)
+}
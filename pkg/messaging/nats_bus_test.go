@@ -0,0 +1,238 @@
+package messaging
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/claims"
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/tracing"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeNatsServer speaks just enough of the NATS protocol to accept clients,
+// so tests can drop their connections and watch them reconnect.
+type fakeNatsServer struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+	lines []string
+}
+
+func startFakeNatsServer(t *testing.T) *fakeNatsServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeNatsServer{ln: ln}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.dropConnections()
+	})
+	go s.accept()
+	return s
+}
+
+func (s *fakeNatsServer) url() string {
+	return "nats://" + s.ln.Addr().String()
+}
+
+func (s *fakeNatsServer) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+func (s *fakeNatsServer) serve(conn net.Conn) {
+	port := s.ln.Addr().(*net.TCPAddr).Port
+	fmt.Fprintf(conn, `INFO {"server_id":"fake","version":"2.10.0","host":"127.0.0.1","port":%d,"max_payload":1048576,"proto":1,"headers":true,"nonce":"nonce"}`+"\r\n", port)
+	lines := bufio.NewScanner(conn)
+	for lines.Scan() {
+		s.mu.Lock()
+		s.lines = append(s.lines, lines.Text())
+		s.mu.Unlock()
+		if strings.HasPrefix(lines.Text(), "PING") {
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
+
+// received reports whether a client sent a protocol line starting with
+// prefix.
+func (s *fakeNatsServer) received(prefix string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range s.lines {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropConnections closes every client connection, as a broker restart would.
+func (s *fakeNatsServer) dropConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// stateRecorder collects connection state changes.
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ConnectionState
+}
+
+func (r *stateRecorder) record(state ConnectionState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, state)
+}
+
+func (r *stateRecorder) seen(state ConnectionState) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func TestShouldReportReconnectOfNatsBus(t *testing.T) {
+	// Arrange
+	server := startFakeNatsServer(t)
+	bus, err := dialNatsBus(server.url(), nats.ReconnectWait(10*time.Millisecond))
+	require.NoError(t, err)
+	defer bus.Close()
+	recorder := &stateRecorder{}
+	unregister := bus.OnConnectionStateChange(recorder.record)
+	defer unregister()
+
+	// Act
+	server.dropConnections()
+
+	// Assert
+	assert.Eventually(t, func() bool {
+		return recorder.seen(ConnectionDisconnected) && recorder.seen(ConnectionReconnected)
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestShouldRoundTripTracingIDsAndPrincipalThroughNatsHeaders(t *testing.T) {
+	// Arrange
+	correlationID, causationID := uuid.New(), uuid.New()
+	subject := uuid.NewString()
+	ctx := messaging.ContextWithTracing(context.Background(), correlationID, causationID)
+	ctx = messaging.ContextWithUserPrincipal(ctx, claims.NewPrincipal(claims.NewClaimsSet(subject)))
+
+	// Act
+	got := natsContext(natsHeader(ctx))
+
+	// Assert
+	gotCorrelationID, gotCausationID := messaging.GetTracing(got)
+	assert.Equal(t, correlationID, gotCorrelationID)
+	assert.Equal(t, causationID, gotCausationID)
+	user, ok := messaging.GetUserPrincipal(got)
+	require.True(t, ok)
+	assert.Equal(t, subject, user.Subject())
+}
+
+func TestShouldSendTraceContextInHeadersWithoutTouchingTracingIDs(t *testing.T) {
+	// Arrange
+	correlationID := uuid.New()
+	sc := newTestSpanContext()
+	ctx := messaging.ContextWithTracing(context.Background(), correlationID, uuid.Nil)
+	ctx = trace.ContextWithSpanContext(ctx, sc)
+
+	// Act
+	h := natsHeader(ctx)
+
+	// Assert
+	assert.NotEmpty(t, h.Get(tracing.TraceParentKey))
+	assert.Equal(t, correlationID.String(), h.Get(correlationIDHeader))
+	assert.Empty(t, h.Get(causationIDHeader))
+	got := trace.SpanContextFromContext(natsContext(h))
+	assert.Equal(t, sc.TraceID(), got.TraceID())
+	assert.Equal(t, sc.SpanID(), got.SpanID())
+}
+
+func TestShouldReportConnectionStateOfFactoryBus(t *testing.T) {
+	// Arrange
+	server := startFakeNatsServer(t)
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("token"))
+	}))
+	defer auth.Close()
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	factory := &DefaultMessageBusFactory{user: user, authURL: auth.URL, brokerURL: server.url()}
+	bus, err := factory.Get(context.Background())
+	require.NoError(t, err)
+	notifier, ok := bus.(ConnectionStateNotifier)
+	require.True(t, ok, "factory bus should report connection state")
+	_, ok = bus.(QueueSubscriber)
+	require.True(t, ok, "factory bus should support queue groups")
+	recorder := &stateRecorder{}
+	unregister := notifier.OnConnectionStateChange(recorder.record)
+	defer unregister()
+
+	// Act
+	server.dropConnections()
+	require.Eventually(t, func() bool { return recorder.seen(ConnectionDisconnected) }, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, bus.Close())
+
+	// Assert
+	assert.Eventually(t, func() bool { return recorder.seen(ConnectionClosed) }, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestShouldSubscribeFactoryBusToQueueGroup(t *testing.T) {
+	// Arrange
+	server := startFakeNatsServer(t)
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("token"))
+	}))
+	defer auth.Close()
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	factory := &DefaultMessageBusFactory{user: user, authURL: auth.URL, brokerURL: server.url()}
+	bus, err := factory.Get(context.Background())
+	require.NoError(t, err)
+	defer bus.Close()
+	route := (&testMessage{}).GetRoute()
+	handler := func(ctx context.Context, msg messaging.Message) error { return nil }
+
+	// Act
+	sub, err := bus.(QueueSubscriber).SubscribeWithOptions(route, handler, messaging.SubscriptionOpts{QueueGroup: "workers"})
+
+	// Assert
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	assert.Eventually(t, func() bool {
+		return server.received("SUB " + natsSubject(route) + " workers ")
+	}, 5*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,240 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fgrzl/enumerators"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/localstore"
+)
+
+// OffsetStore persists consumer offsets keyed by consumer name.
+type OffsetStore interface {
+	// Load returns the saved offset for consumer, or nil if none was saved.
+	Load(ctx context.Context, consumer string) (*ConsumerOffset, error)
+	// Save replaces the saved offset for consumer.
+	Save(ctx context.Context, consumer string, offset *ConsumerOffset) error
+}
+
+// WithOffsetStore loads the starting offset from store and flushes offsets to
+// it under consumer, replacing any registered offset loader and flush hook.
+func WithOffsetStore(store OffsetStore, consumer string) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.loadOffset = func(ctx context.Context) (*ConsumerOffset, error) {
+			return store.Load(ctx, consumer)
+		}
+		p.flushOffset = func(ctx context.Context, offset *ConsumerOffset) error {
+			return store.Save(ctx, consumer, offset)
+		}
+	}
+}
+
+// MemoryOffsetStore keeps offsets in memory. It is intended for tests.
+type MemoryOffsetStore struct {
+	mu      sync.Mutex
+	offsets map[string]*ConsumerOffset
+}
+
+func NewMemoryOffsetStore() *MemoryOffsetStore {
+	return &MemoryOffsetStore{offsets: make(map[string]*ConsumerOffset)}
+}
+
+func (s *MemoryOffsetStore) Load(ctx context.Context, consumer string) (*ConsumerOffset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, ok := s.offsets[consumer]
+	if !ok {
+		return nil, nil
+	}
+	return offset.clone(), nil
+}
+
+func (s *MemoryOffsetStore) Save(ctx context.Context, consumer string, offset *ConsumerOffset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offsets[consumer] = offset.clone()
+	return nil
+}
+
+// FileOffsetStore keeps one JSON file per consumer in a directory. Writes go
+// to a temporary file that is renamed over the previous one, so a crash never
+// leaves a partially written offset behind.
+type FileOffsetStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileOffsetStore stores offsets in dir, creating it if needed.
+func NewFileOffsetStore(dir string) (*FileOffsetStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create offset directory: %w", err)
+	}
+	return &FileOffsetStore{dir: dir}, nil
+}
+
+// NewTenantFileOffsetStore stores offsets under the tenant's data path, see
+// localstore.GetDataPath.
+func NewTenantFileOffsetStore(tenantID uuid.UUID) (*FileOffsetStore, error) {
+	dataPath, err := localstore.GetDataPath(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileOffsetStore(filepath.Join(dataPath, "offsets"))
+}
+
+// fileName encodes name for use as a file name. Bytes other than lower-case
+// letters, digits and '-' are written as '_' followed by two hex digits, so
+// distinct names never share a file, even on case-insensitive file systems.
+func fileName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+func (s *FileOffsetStore) path(consumer string) string {
+	return filepath.Join(s.dir, fileName(consumer)+".json")
+}
+
+func (s *FileOffsetStore) Load(ctx context.Context, consumer string) (*ConsumerOffset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path(consumer))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offset for %q: %w", consumer, err)
+	}
+	var offset ConsumerOffset
+	if err := json.Unmarshal(data, &offset); err != nil {
+		return nil, fmt.Errorf("failed to decode offset for %q: %w", consumer, err)
+	}
+	return &offset, nil
+}
+
+func (s *FileOffsetStore) Save(ctx context.Context, consumer string, offset *ConsumerOffset) error {
+	data, err := json.Marshal(offset)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target := s.path(consumer)
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create offset file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write offset file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync offset file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close offset file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to replace offset file: %w", err)
+	}
+	return nil
+}
+
+// OffsetStream is the part of streamkit.Client a StreamOffsetStore uses.
+// Every streamkit.Client satisfies it, as does memstream.Client.
+type OffsetStream interface {
+	Peek(ctx context.Context, storeID uuid.UUID, space, segment string) (*streamkit.Entry, error)
+	Produce(ctx context.Context, storeID uuid.UUID, space, segment string, records enumerators.Enumerator[*streamkit.Record]) enumerators.Enumerator[*streamkit.SegmentStatus]
+}
+
+// StreamOffsetStore records offsets in a dedicated streamkit space, one
+// segment per consumer. Load peeks at the newest record of the consumer's
+// segment, so its cost does not grow with the number of saved offsets.
+type StreamOffsetStore struct {
+	stream  OffsetStream
+	storeID uuid.UUID
+	space   string
+
+	mu        sync.Mutex
+	sequences map[string]uint64
+}
+
+// NewStreamOffsetStore records offsets into space of storeID.
+func NewStreamOffsetStore(stream OffsetStream, storeID uuid.UUID, space string) *StreamOffsetStore {
+	return &StreamOffsetStore{
+		stream:    stream,
+		storeID:   storeID,
+		space:     space,
+		sequences: make(map[string]uint64),
+	}
+}
+
+func (s *StreamOffsetStore) Load(ctx context.Context, consumer string) (*ConsumerOffset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, _, err := s.load(ctx, consumer)
+	return offset, err
+}
+
+// load reads the newest record of the consumer's segment and remembers its
+// sequence so the next Save continues the segment.
+func (s *StreamOffsetStore) load(ctx context.Context, consumer string) (*ConsumerOffset, uint64, error) {
+	latest, err := s.stream.Peek(ctx, s.storeID, s.space, consumer)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read offset for %q from space %q: %w", consumer, s.space, err)
+	}
+	if latest == nil || latest.Sequence == 0 {
+		s.sequences[consumer] = 0
+		return nil, 0, nil
+	}
+
+	var offset ConsumerOffset
+	if err := json.Unmarshal(latest.Payload, &offset); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode offset for %q: %w", consumer, err)
+	}
+	s.sequences[consumer] = latest.Sequence
+	return &offset, latest.Sequence, nil
+}
+
+func (s *StreamOffsetStore) Save(ctx context.Context, consumer string, offset *ConsumerOffset) error {
+	data, err := json.Marshal(offset)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sequence, ok := s.sequences[consumer]
+	if !ok {
+		if _, sequence, err = s.load(ctx, consumer); err != nil {
+			return err
+		}
+	}
+
+	record := &streamkit.Record{Sequence: sequence + 1, Payload: data}
+	results := s.stream.Produce(ctx, s.storeID, s.space, consumer, enumerators.Slice([]*streamkit.Record{record}))
+	if err := enumerators.Consume(results); err != nil {
+		return fmt.Errorf("failed to record offset for %q: %w", consumer, err)
+	}
+	s.sequences[consumer] = record.Sequence
+	return nil
+}
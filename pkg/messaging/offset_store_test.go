@@ -0,0 +1,142 @@
+package messaging
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/fgrzl/lexkey"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/messaging/memstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldRoundTripOffsetsInMemoryStore(t *testing.T) {
+	// Arrange
+	store := NewMemoryOffsetStore()
+	offset := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"space": lexkey.Encode("space", 1)}}
+
+	// Act
+	require.NoError(t, store.Save(context.Background(), "consumer", offset))
+	offset.Offsets["space"] = lexkey.Empty
+	got, err := store.Load(context.Background(), "consumer")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, lexkey.Encode("space", 1), got.Offsets["space"])
+}
+
+func TestShouldReturnNilOffsetWhenNoneSaved(t *testing.T) {
+	// Arrange
+	fileStore, err := NewFileOffsetStore(t.TempDir())
+	require.NoError(t, err)
+
+	for name, store := range map[string]OffsetStore{"memory": NewMemoryOffsetStore(), "file": fileStore} {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			got, err := store.Load(context.Background(), "missing")
+
+			// Assert
+			require.NoError(t, err)
+			assert.Nil(t, got)
+		})
+	}
+}
+
+func TestShouldReplaceOffsetFileAtomically(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	store, err := NewFileOffsetStore(dir)
+	require.NoError(t, err)
+	first := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"space": lexkey.Encode("space", 1)}}
+	second := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"space": lexkey.Encode("space", 2)}}
+
+	// Act
+	require.NoError(t, store.Save(context.Background(), "orders/projection", first))
+	require.NoError(t, store.Save(context.Background(), "orders/projection", second))
+	got, err := store.Load(context.Background(), "orders/projection")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, second.Offsets, got.Offsets)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary files must not be left behind")
+	assert.Equal(t, "orders_2fprojection.json", entries[0].Name())
+}
+
+func TestShouldKeepOffsetsOfSimilarConsumerNamesApart(t *testing.T) {
+	// Arrange
+	store, err := NewFileOffsetStore(t.TempDir())
+	require.NoError(t, err)
+	consumers := []string{"a/b", "a_b", "A_b", "a.b"}
+	for i, consumer := range consumers {
+		offset := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"space": lexkey.Encode("space", i)}}
+		require.NoError(t, store.Save(context.Background(), consumer, offset))
+	}
+
+	// Act & Assert
+	for i, consumer := range consumers {
+		got, err := store.Load(context.Background(), consumer)
+		require.NoError(t, err)
+		assert.Equal(t, lexkey.Encode("space", i), got.Offsets["space"], consumer)
+	}
+}
+
+func TestShouldWireOffsetStoreIntoConsumer(t *testing.T) {
+	// Arrange
+	store := NewMemoryOffsetStore()
+	saved := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"space": lexkey.Encode("space", 3)}}
+	require.NoError(t, store.Save(context.Background(), "consumer", saved))
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+
+	// Act
+	WithOffsetStore(store, "consumer")(p)
+	loaded, err := p.loadOffset(context.Background())
+	require.NoError(t, err)
+	loaded.Offsets["space"] = lexkey.Encode("space", 4)
+	require.NoError(t, p.flushOffset(context.Background(), loaded))
+
+	// Assert
+	got, err := store.Load(context.Background(), "consumer")
+	require.NoError(t, err)
+	assert.Equal(t, lexkey.Encode("space", 4), got.Offsets["space"])
+}
+
+func TestShouldLoadNewestStreamOffsetOfConsumer(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	stream := memstream.New()
+	storeID := uuid.New()
+	writer := NewStreamOffsetStore(stream, storeID, "offsets")
+	for i := range 3 {
+		offset := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"space": lexkey.Encode("space", i)}}
+		require.NoError(t, writer.Save(ctx, "consumer", offset))
+	}
+	other := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"space": lexkey.Encode("space", 99)}}
+	require.NoError(t, writer.Save(ctx, "other", other))
+
+	// Act
+	reader := NewStreamOffsetStore(stream, storeID, "offsets")
+	got, err := reader.Load(ctx, "consumer")
+	require.NoError(t, err)
+	saveErr := reader.Save(ctx, "consumer", got)
+
+	// Assert
+	assert.Equal(t, lexkey.Encode("space", 2), got.Offsets["space"])
+	assert.NoError(t, saveErr)
+	assert.Len(t, stream.Entries(storeID, "offsets"), 5)
+}
+
+func TestShouldReturnNilStreamOffsetWhenNoneSaved(t *testing.T) {
+	// Arrange
+	store := NewStreamOffsetStore(memstream.New(), uuid.New(), "offsets")
+
+	// Act
+	got, err := store.Load(context.Background(), "consumer")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
@@ -1,8 +1,11 @@
 package messaging
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
 	"github.com/fgrzl/messaging"
-	streamkit "github.com/fgrzl/streamkit/pkg/client"
 	"github.com/google/uuid"
 	"github.com/hydn-co/mesh-sdk/pkg/lifecycle"
 )
@@ -13,33 +16,146 @@ type Processor interface {
 	StreamProcessor
 }
 
+// ProcessorOptions configure GlobalProcessorBase and ScopedProcessorBase.
+type ProcessorOptions func(*processorOptions)
+
+type processorOptions struct {
+	bus        []MessageBusProcessorOptions
+	monitor    []RequestHandlerMonitorOptions
+	noMonitor  bool
+	consumer   []ConsumerOptions
+	noConsumer bool
+}
+
+// WithMessageBusOptions applies opts to the embedded MessageBusProcessorBase.
+func WithMessageBusOptions(opts ...MessageBusProcessorOptions) ProcessorOptions {
+	return func(o *processorOptions) {
+		o.bus = append(o.bus, opts...)
+	}
+}
+
+// WithRequestMonitorOptions configures the request handler monitor enabled
+// by Start.
+func WithRequestMonitorOptions(opts ...RequestHandlerMonitorOptions) ProcessorOptions {
+	return func(o *processorOptions) {
+		o.monitor = append(o.monitor, opts...)
+	}
+}
+
+// WithoutRequestMonitoring stops Start from enabling request monitoring.
+func WithoutRequestMonitoring() ProcessorOptions {
+	return func(o *processorOptions) {
+		o.noMonitor = true
+	}
+}
+
+// WithConsumerOptions passes opts to StartConsumer when the processor starts.
+func WithConsumerOptions(opts ...ConsumerOptions) ProcessorOptions {
+	return func(o *processorOptions) {
+		o.consumer = append(o.consumer, opts...)
+	}
+}
+
+// WithoutConsumer stops Start from starting the stream consumer, for
+// processors that only handle bus traffic or start the consumer themselves.
+func WithoutConsumer() ProcessorOptions {
+	return func(o *processorOptions) {
+		o.noConsumer = true
+	}
+}
+
+func buildProcessorOptions(opts []ProcessorOptions) *processorOptions {
+	o := &processorOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
 func NewGlobalProcessorBase(
 	bus messaging.MessageBus,
-	stream streamkit.Client,
+	stream StreamClient,
+	opts ...ProcessorOptions,
 ) *GlobalProcessorBase {
+	o := buildProcessorOptions(opts)
 	return &GlobalProcessorBase{
-		MessageBusProcessorBase: NewMessageBusProcessorBase(bus),
+		MessageBusProcessorBase: NewMessageBusProcessorBase(bus, o.bus...),
 		StreamProcessorBase:     NewStreamProcessorBase(stream, uuid.Nil),
+		opts:                    o,
 	}
 }
 
 type GlobalProcessorBase struct {
 	*MessageBusProcessorBase
 	*StreamProcessorBase
+	opts *processorOptions
+}
+
+// Start enables request monitoring, covering request handlers registered
+// before and after it, and starts the stream consumer.
+func (p *GlobalProcessorBase) Start(ctx context.Context) error {
+	return startProcessor(ctx, p.MessageBusProcessorBase, p.StreamProcessorBase, p.opts)
+}
+
+// Stop stops the stream consumer, unsubscribes every bus subscription and
+// waits for in-flight handlers, bounded by ctx.
+func (p *GlobalProcessorBase) Stop(ctx context.Context) error {
+	return stopProcessor(ctx, p.MessageBusProcessorBase, p.StreamProcessorBase)
 }
 
 func NewScopedProcessorBase(
 	bus messaging.MessageBus,
-	stream streamkit.Client,
+	stream StreamClient,
 	storeID uuid.UUID,
+	opts ...ProcessorOptions,
 ) *ScopedProcessorBase {
+	o := buildProcessorOptions(opts)
 	return &ScopedProcessorBase{
-		MessageBusProcessorBase: NewMessageBusProcessorBase(bus),
+		MessageBusProcessorBase: NewMessageBusProcessorBase(bus, o.bus...),
 		StreamProcessorBase:     NewStreamProcessorBase(stream, storeID),
+		opts:                    o,
 	}
 }
 
 type ScopedProcessorBase struct {
 	*MessageBusProcessorBase
 	*StreamProcessorBase
+	opts *processorOptions
+}
+
+// Start enables request monitoring, covering request handlers registered
+// before and after it, and starts the stream consumer.
+func (p *ScopedProcessorBase) Start(ctx context.Context) error {
+	return startProcessor(ctx, p.MessageBusProcessorBase, p.StreamProcessorBase, p.opts)
+}
+
+// Stop stops the stream consumer, unsubscribes every bus subscription and
+// waits for in-flight handlers, bounded by ctx.
+func (p *ScopedProcessorBase) Stop(ctx context.Context) error {
+	return stopProcessor(ctx, p.MessageBusProcessorBase, p.StreamProcessorBase)
+}
+
+func startProcessor(ctx context.Context, bus *MessageBusProcessorBase, stream *StreamProcessorBase, o *processorOptions) error {
+	if !o.noMonitor {
+		bus.EnableRequestMonitoring(ctx, o.monitor...)
+	}
+	if !o.noConsumer {
+		if err := stream.StartConsumer(ctx, o.consumer...); err != nil {
+			return fmt.Errorf("failed to start stream consumer: %w", err)
+		}
+	}
+	return nil
+}
+
+// stopProcessor stops the consumer first so no stream handler is mid-flight
+// when the bus side drains.
+func stopProcessor(ctx context.Context, bus *MessageBusProcessorBase, stream *StreamProcessorBase) error {
+	var errs []error
+	if err := stream.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to stop stream consumer: %w", err))
+	}
+	if err := bus.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shut down message bus processor: %w", err))
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,189 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldImplementProcessor(t *testing.T) {
+	// Assert
+	var _ Processor = NewGlobalProcessorBase(nil, nil)
+	var _ Processor = NewScopedProcessorBase(nil, nil, uuid.New())
+}
+
+func TestShouldMonitorRequestHandlersRegisteredAfterStart(t *testing.T) {
+	// Arrange
+	bus := newMonitorBus()
+	p := NewGlobalProcessorBase(bus, nil, WithRequestMonitorOptions(WithFallbackRefresh(time.Hour)))
+	require.NoError(t, p.Start(context.Background()))
+
+	// Act
+	sub, err := p.RegisterRequestHandler((&testRequest{}).GetRoute(), noopRequestHandler)
+
+	// Assert
+	require.NoError(t, err)
+	assert.IsType(t, &monitoredSubscription{}, sub)
+	require.NoError(t, p.Stop(context.Background()))
+	_, active := bus.subscriptions()
+	assert.Equal(t, 0, active)
+	assert.Equal(t, 0, p.SubscriptionTracker.(RouteTracker).Count())
+}
+
+func TestShouldNotMonitorWhenDisabled(t *testing.T) {
+	// Arrange
+	bus := newMonitorBus()
+	p := NewScopedProcessorBase(bus, nil, uuid.New(), WithoutRequestMonitoring())
+	require.NoError(t, p.Start(context.Background()))
+	defer p.Stop(context.Background())
+
+	// Act
+	sub, err := p.RegisterRequestHandler((&testRequest{}).GetRoute(), noopRequestHandler)
+
+	// Assert
+	require.NoError(t, err)
+	assert.IsType(t, &requestSubscription{}, sub)
+	assert.Nil(t, p.monitor)
+}
+
+func TestShouldMonitorRequestHandlersRegisteredBeforeStart(t *testing.T) {
+	// Arrange
+	bus := newMonitorBus()
+	p := NewGlobalProcessorBase(bus, nil, WithoutConsumer(), WithRequestMonitorOptions(WithFallbackRefresh(time.Hour)))
+	sub, err := p.RegisterRequestHandler((&testRequest{}).GetRoute(), noopRequestHandler)
+	require.NoError(t, err)
+
+	// Act
+	require.NoError(t, p.Start(context.Background()))
+
+	// Assert
+	total, active := bus.subscriptions()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, active)
+	require.NoError(t, sub.Unsubscribe())
+	_, active = bus.subscriptions()
+	assert.Equal(t, 0, active)
+	require.NoError(t, p.Stop(context.Background()))
+}
+
+func TestShouldStartNewMonitorWhenRestartedAfterStop(t *testing.T) {
+	// Arrange
+	bus := newMonitorBus()
+	p := NewGlobalProcessorBase(bus, nil, WithoutConsumer(), WithRequestMonitorOptions(WithFallbackRefresh(time.Hour)))
+	require.NoError(t, p.Start(context.Background()))
+	first := p.monitor
+	require.NoError(t, p.Stop(context.Background()))
+
+	// Act
+	require.NoError(t, p.Start(context.Background()))
+	defer p.Stop(context.Background())
+	_, err := p.RegisterRequestHandler((&testRequest{}).GetRoute(), noopRequestHandler)
+	require.NoError(t, err)
+	p.monitor.Refresh()
+
+	// Assert
+	assert.NotSame(t, first, p.monitor)
+	require.Eventually(t, func() bool {
+		total, active := bus.subscriptions()
+		return total == 2 && active == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestShouldDrainInFlightHandlersOnStop(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	var registered messaging.MessageHandler
+	bus.Mock.On("Subscribe", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { registered = args.Get(1).(messaging.MessageHandler) }).
+		Return(&mockSubscription{id: uuid.New()}, nil).Once()
+	p := NewGlobalProcessorBase(bus, nil, WithoutRequestMonitoring())
+	require.NoError(t, p.Start(context.Background()))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	_, err := p.RegisterMessageHandler(messaging.NewInternalRoute("test", "message"), func(ctx context.Context, msg messaging.Message) error {
+		close(started)
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+	go func() { _ = registered(context.Background(), &testMessage{}) }()
+	<-started
+
+	// Act
+	stopped := make(chan error, 1)
+	go func() { stopped <- p.Stop(context.Background()) }()
+
+	// Assert
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the in-flight handler completed")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-stopped)
+}
+
+func TestShouldReturnErrorWhenDrainExceedsStopContext(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	var registered messaging.MessageHandler
+	bus.Mock.On("Subscribe", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { registered = args.Get(1).(messaging.MessageHandler) }).
+		Return(&mockSubscription{id: uuid.New()}, nil).Once()
+	p := NewGlobalProcessorBase(bus, nil)
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	_, err := p.RegisterMessageHandler(messaging.NewInternalRoute("test", "message"), func(ctx context.Context, msg messaging.Message) error {
+		close(started)
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+	go func() { _ = registered(context.Background(), &testMessage{}) }()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err = p.Stop(ctx)
+
+	// Assert
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestShouldDrainHandlersAbandonedByTimeoutMiddleware(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	var registered messaging.MessageHandler
+	bus.Mock.On("Subscribe", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { registered = args.Get(1).(messaging.MessageHandler) }).
+		Return(&mockSubscription{id: uuid.New()}, nil).Once()
+	p := NewMessageBusProcessorBase(bus, WithMiddleware(TimeoutMiddleware(10*time.Millisecond)))
+	release := make(chan struct{})
+	_, err := p.RegisterMessageHandler(messaging.NewInternalRoute("test", "message"), func(context.Context, messaging.Message) error {
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+	require.ErrorIs(t, registered(context.Background(), &testMessage{}), ErrHandlerTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	busyErr := p.Drain(ctx)
+	close(release)
+	idleErr := p.Drain(context.Background())
+
+	// Assert
+	assert.ErrorIs(t, busyErr, context.DeadlineExceeded)
+	assert.NoError(t, idleErr)
+}
@@ -2,22 +2,79 @@ package messaging
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
 )
 
-// RequestHandlerMonitor periodically refreshes NATS subscriptions to ensure they remain valid.
-// This uses a proactive approach - recreating all subscriptions every cycle rather than
-// relying on health checks, since the Subscription interface doesn't expose connection state.
+// DefaultFallbackRefreshInterval is the periodic refresh interval used when
+// the bus cannot report connection state changes and no interval is configured.
+const DefaultFallbackRefreshInterval = 15 * time.Second
+
+// ConnectionState describes the state of a message bus connection.
+type ConnectionState int
+
+const (
+	ConnectionConnected ConnectionState = iota
+	ConnectionDisconnected
+	ConnectionReconnected
+	ConnectionClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionConnected:
+		return "connected"
+	case ConnectionDisconnected:
+		return "disconnected"
+	case ConnectionReconnected:
+		return "reconnected"
+	case ConnectionClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ConnectionState(%d)", int(s))
+	}
+}
+
+// ConnectionStateNotifier is implemented by message buses that report
+// connection state changes, including buses returned by NewMessageBusFactory.
+// The returned function unregisters the handler.
+type ConnectionStateNotifier interface {
+	OnConnectionStateChange(handler func(ConnectionState)) (unregister func())
+}
+
+// RequestHandlerMonitorOptions configure a RequestHandlerMonitor.
+type RequestHandlerMonitorOptions func(*RequestHandlerMonitor)
+
+// WithFallbackRefresh resubscribes every handler each interval in addition to
+// any event-driven recovery. A zero interval disables the periodic refresh
+// for buses that implement ConnectionStateNotifier; buses that do not always
+// refresh periodically, using DefaultFallbackRefreshInterval when unset.
+func WithFallbackRefresh(interval time.Duration) RequestHandlerMonitorOptions {
+	return func(m *RequestHandlerMonitor) {
+		m.refreshInterval = interval
+	}
+}
+
+// RequestHandlerMonitor keeps request handler subscriptions alive across
+// connection loss. When the bus implements ConnectionStateNotifier handlers
+// are resubscribed on reconnect; otherwise, or when WithFallbackRefresh is
+// set, they are also refreshed periodically. Refreshes subscribe the new
+// handler before dropping the old one so the route never lacks a responder.
 type RequestHandlerMonitor struct {
-	ctx      context.Context
-	cancel   context.CancelFunc
-	bus      messaging.MessageBus
+	ctx             context.Context
+	cancel          context.CancelFunc
+	bus             messaging.MessageBus
+	refreshInterval time.Duration
+	refresh         chan struct{}
+	unregister      func()
+
 	mu       sync.RWMutex
-	handlers map[string]handlerInfo
+	handlers map[uuid.UUID]*handlerInfo
 }
 
 type handlerInfo struct {
@@ -26,28 +83,45 @@ type handlerInfo struct {
 	sub     messaging.Subscription
 }
 
-// NewRequestHandlerMonitor creates a monitor that proactively refreshes request handlers.
-func NewRequestHandlerMonitor(ctx context.Context, bus messaging.MessageBus) *RequestHandlerMonitor {
+// NewRequestHandlerMonitor creates a monitor that resubscribes request
+// handlers when the bus connection recovers.
+func NewRequestHandlerMonitor(ctx context.Context, bus messaging.MessageBus, opts ...RequestHandlerMonitorOptions) *RequestHandlerMonitor {
 	ctx, cancel := context.WithCancel(ctx)
 	m := &RequestHandlerMonitor{
 		ctx:      ctx,
 		cancel:   cancel,
 		bus:      bus,
-		handlers: make(map[string]handlerInfo),
+		refresh:  make(chan struct{}, 1),
+		handlers: make(map[uuid.UUID]*handlerInfo),
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+
+	if notifier, ok := bus.(ConnectionStateNotifier); ok {
+		m.unregister = notifier.OnConnectionStateChange(m.onConnectionStateChange)
+	} else if m.refreshInterval <= 0 {
+		slog.DebugContext(ctx, "message bus does not report connection state; using periodic refresh",
+			"interval", DefaultFallbackRefreshInterval)
+		m.refreshInterval = DefaultFallbackRefreshInterval
+	}
+
 	go m.monitor()
 	return m
 }
 
-// Register adds a request handler to be monitored.
+// Register subscribes handler on route and keeps it subscribed. Several
+// handlers may be registered on the same route. The returned subscription
+// stays valid across refreshes; unsubscribing it stops monitoring.
 func (m *RequestHandlerMonitor) Register(route messaging.Route, handler messaging.RequestHandler) (messaging.Subscription, error) {
 	sub, err := m.bus.SubscribeRequest(route, handler)
 	if err != nil {
 		return nil, err
 	}
 
+	id := uuid.New()
 	m.mu.Lock()
-	m.handlers[route.String()] = handlerInfo{
+	m.handlers[id] = &handlerInfo{
 		route:   route,
 		handler: handler,
 		sub:     sub,
@@ -55,62 +129,153 @@ func (m *RequestHandlerMonitor) Register(route messaging.Route, handler messagin
 	m.mu.Unlock()
 
 	slog.InfoContext(m.ctx, "registered monitored request handler", "route", route.String())
-	return sub, nil
+	return &monitoredSubscription{id: id, monitor: m}, nil
+}
+
+// Refresh requests an immediate resubscription of every handler. Concurrent
+// requests are coalesced.
+func (m *RequestHandlerMonitor) Refresh() {
+	select {
+	case m.refresh <- struct{}{}:
+	default:
+	}
 }
 
-// monitor proactively refreshes all handler subscriptions every cycle.
-// This approach handles cases where subscriptions die silently without any way to detect it.
+func (m *RequestHandlerMonitor) onConnectionStateChange(state ConnectionState) {
+	switch state {
+	case ConnectionReconnected:
+		slog.InfoContext(m.ctx, "message bus reconnected; refreshing request handlers")
+		m.Refresh()
+	case ConnectionDisconnected:
+		slog.WarnContext(m.ctx, "message bus disconnected; request handlers will be refreshed on reconnect")
+	case ConnectionClosed:
+		slog.ErrorContext(m.ctx, "message bus closed; request handlers can no longer be recovered")
+	}
+}
+
+// monitor serializes refreshes triggered by connection events and, when
+// configured, the periodic fallback.
 func (m *RequestHandlerMonitor) monitor() {
-	ticker := time.NewTicker(15 * time.Second)
-	defer ticker.Stop()
+	var tick <-chan time.Time
+	if m.refreshInterval > 0 {
+		ticker := time.NewTicker(m.refreshInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 
 	for {
 		select {
 		case <-m.ctx.Done():
 			return
-		case <-ticker.C:
-			m.mu.Lock()
-			// Proactively recreate all subscriptions to ensure they're valid
-			// This handles cases where the underlying connection died silently
-			for key, info := range m.handlers {
-				// Unsubscribe the old one (may fail if already dead, that's ok)
-				if info.sub != nil {
-					_ = info.sub.Unsubscribe()
-				}
-
-				// Create fresh subscription
-				newSub, err := m.bus.SubscribeRequest(info.route, info.handler)
-				if err != nil {
-					slog.ErrorContext(m.ctx, "failed to refresh request handler subscription",
-						"route", info.route.String(),
-						"error", err)
-					continue
-				}
-
-				// Update with new subscription
-				m.handlers[key] = handlerInfo{
-					route:   info.route,
-					handler: info.handler,
-					sub:     newSub,
-				}
-				slog.DebugContext(m.ctx, "refreshed request handler subscription", "route", info.route.String())
-			}
-			m.mu.Unlock()
+		case <-m.refresh:
+			m.refreshAll()
+		case <-tick:
+			m.refreshAll()
+		}
+	}
+}
+
+// refreshAll resubscribes every registered handler. Network calls are made
+// without holding the lock so registration is never blocked by a refresh.
+func (m *RequestHandlerMonitor) refreshAll() {
+	m.mu.RLock()
+	ids := make([]uuid.UUID, 0, len(m.handlers))
+	for id := range m.handlers {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+
+	for _, id := range ids {
+		if m.ctx.Err() != nil {
+			return
 		}
+		m.refreshOne(id)
+	}
+}
+
+func (m *RequestHandlerMonitor) refreshOne(id uuid.UUID) {
+	m.mu.RLock()
+	info, ok := m.handlers[id]
+	var route messaging.Route
+	var handler messaging.RequestHandler
+	if ok {
+		route, handler = info.route, info.handler
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	newSub, err := m.bus.SubscribeRequest(route, handler)
+	if err != nil {
+		slog.ErrorContext(m.ctx, "failed to refresh request handler subscription",
+			"route", route.String(),
+			"error", err)
+		return
+	}
+
+	m.mu.Lock()
+	info, ok = m.handlers[id]
+	var oldSub messaging.Subscription
+	if ok {
+		oldSub = info.sub
+		info.sub = newSub
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		// unregistered while we were subscribing
+		_ = newSub.Unsubscribe()
+		return
 	}
+	if oldSub != nil {
+		// may fail if the old subscription already died with the connection
+		_ = oldSub.Unsubscribe()
+	}
+	slog.DebugContext(m.ctx, "refreshed request handler subscription", "route", route.String())
+}
+
+func (m *RequestHandlerMonitor) unregisterHandler(id uuid.UUID) error {
+	m.mu.Lock()
+	info, ok := m.handlers[id]
+	delete(m.handlers, id)
+	m.mu.Unlock()
+
+	if !ok || info.sub == nil {
+		return nil
+	}
+	return info.sub.Unsubscribe()
 }
 
 // Stop stops the monitor and unsubscribes all handlers.
 func (m *RequestHandlerMonitor) Stop() {
 	m.cancel()
+	if m.unregister != nil {
+		m.unregister()
+	}
 
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	handlers := m.handlers
+	m.handlers = make(map[uuid.UUID]*handlerInfo)
+	m.mu.Unlock()
 
-	for _, info := range m.handlers {
+	for _, info := range handlers {
 		if info.sub != nil {
 			_ = info.sub.Unsubscribe()
 		}
 	}
-	m.handlers = make(map[string]handlerInfo)
+}
+
+// monitoredSubscription is the stable handle returned by Register.
+type monitoredSubscription struct {
+	id      uuid.UUID
+	monitor *RequestHandlerMonitor
+}
+
+func (s *monitoredSubscription) GetID() uuid.UUID {
+	return s.id
+}
+
+func (s *monitoredSubscription) Unsubscribe() error {
+	return s.monitor.unregisterHandler(s.id)
 }
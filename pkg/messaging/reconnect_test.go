@@ -0,0 +1,190 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// monitorBus records request subscriptions and, when notify is set, reports
+// connection state changes.
+type monitorBus struct {
+	*testkit.MockMessageBus
+
+	mu       sync.Mutex
+	subs     []*monitorSub
+	handlers []func(ConnectionState)
+}
+
+type monitorSub struct {
+	id           uuid.UUID
+	bus          *monitorBus
+	unsubscribed bool
+}
+
+func (s *monitorSub) GetID() uuid.UUID { return s.id }
+
+func (s *monitorSub) Unsubscribe() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.unsubscribed = true
+	return nil
+}
+
+func (b *monitorBus) SubscribeRequest(route messaging.Route, handler messaging.RequestHandler) (messaging.Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &monitorSub{id: uuid.New(), bus: b}
+	b.subs = append(b.subs, sub)
+	return sub, nil
+}
+
+// subscriptions returns the total and still-active subscription counts.
+func (b *monitorBus) subscriptions() (total, active int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.subs {
+		if !s.unsubscribed {
+			active++
+		}
+	}
+	return len(b.subs), active
+}
+
+// notifyingBus is a monitorBus that implements ConnectionStateNotifier.
+type notifyingBus struct {
+	*monitorBus
+}
+
+func (b *notifyingBus) OnConnectionStateChange(handler func(ConnectionState)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.handlers = nil
+	}
+}
+
+func (b *notifyingBus) emit(state ConnectionState) {
+	b.mu.Lock()
+	handlers := append([]func(ConnectionState){}, b.handlers...)
+	b.mu.Unlock()
+	for _, h := range handlers {
+		h(state)
+	}
+}
+
+func newMonitorBus() *monitorBus {
+	return &monitorBus{MockMessageBus: testkit.NewMockMessageBus()}
+}
+
+func noopRequestHandler(ctx context.Context, req messaging.Request) (messaging.Response, error) {
+	return &messaging.Accepted{}, nil
+}
+
+func TestShouldResubscribeHandlersOnReconnect(t *testing.T) {
+	// Arrange
+	bus := &notifyingBus{monitorBus: newMonitorBus()}
+	monitor := NewRequestHandlerMonitor(context.Background(), bus)
+	defer monitor.Stop()
+	_, err := monitor.Register((&testRequest{}).GetRoute(), noopRequestHandler)
+	require.NoError(t, err)
+
+	// Act
+	bus.emit(ConnectionReconnected)
+
+	// Assert
+	require.Eventually(t, func() bool {
+		total, active := bus.subscriptions()
+		return total == 2 && active == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestShouldNotRefreshPeriodicallyWhenBusReportsConnectionState(t *testing.T) {
+	// Arrange
+	bus := &notifyingBus{monitorBus: newMonitorBus()}
+	monitor := NewRequestHandlerMonitor(context.Background(), bus)
+	defer monitor.Stop()
+
+	// Act
+	_, err := monitor.Register((&testRequest{}).GetRoute(), noopRequestHandler)
+	require.NoError(t, err)
+	bus.emit(ConnectionDisconnected)
+	time.Sleep(50 * time.Millisecond)
+
+	// Assert
+	total, active := bus.subscriptions()
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 1, active)
+}
+
+func TestShouldRefreshPeriodicallyWhenFallbackConfigured(t *testing.T) {
+	// Arrange
+	bus := newMonitorBus()
+	monitor := NewRequestHandlerMonitor(context.Background(), bus, WithFallbackRefresh(10*time.Millisecond))
+	defer monitor.Stop()
+
+	// Act
+	_, err := monitor.Register((&testRequest{}).GetRoute(), noopRequestHandler)
+	require.NoError(t, err)
+
+	// Assert
+	require.Eventually(t, func() bool {
+		total, active := bus.subscriptions()
+		return total > 2 && active == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestShouldKeepMultipleHandlersOnSameRoute(t *testing.T) {
+	// Arrange
+	bus := &notifyingBus{monitorBus: newMonitorBus()}
+	monitor := NewRequestHandlerMonitor(context.Background(), bus)
+	defer monitor.Stop()
+	route := (&testRequest{}).GetRoute()
+
+	first, err := monitor.Register(route, noopRequestHandler)
+	require.NoError(t, err)
+	second, err := monitor.Register(route, noopRequestHandler)
+	require.NoError(t, err)
+
+	// Act
+	bus.emit(ConnectionReconnected)
+
+	// Assert
+	assert.NotEqual(t, first.GetID(), second.GetID())
+	require.Eventually(t, func() bool {
+		total, active := bus.subscriptions()
+		return total == 4 && active == 2
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, first.Unsubscribe())
+	_, active := bus.subscriptions()
+	assert.Equal(t, 1, active)
+}
+
+func TestShouldUnsubscribeAllHandlersOnStop(t *testing.T) {
+	// Arrange
+	bus := newMonitorBus()
+	monitor := NewRequestHandlerMonitor(context.Background(), bus, WithFallbackRefresh(time.Hour))
+	route := (&testRequest{}).GetRoute()
+	_, err := monitor.Register(route, noopRequestHandler)
+	require.NoError(t, err)
+	_, err = monitor.Register(route, noopRequestHandler)
+	require.NoError(t, err)
+
+	// Act
+	monitor.Stop()
+
+	// Assert
+	_, active := bus.subscriptions()
+	assert.Equal(t, 0, active)
+}
@@ -0,0 +1,141 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/fgrzl/messaging"
+	"github.com/hydn-co/mesh-sdk/pkg/breaker"
+	"github.com/hydn-co/mesh-sdk/pkg/requestkit"
+)
+
+// DefaultRequestTimeout is how long a RequestClient waits for a reply when no
+// timeout is configured.
+const DefaultRequestTimeout = 5 * time.Second
+
+// ErrRequestTimeout is wrapped by errors returned when a request receives no
+// reply within its timeout.
+var ErrRequestTimeout = requestkit.ErrTimeout
+
+// RemoteError is a failure reported by a request handler through a
+// messaging.ErrorResponse.
+type RemoteError = requestkit.RemoteError
+
+// ErrorDecoder converts a response that reports a failure into an error. It
+// returns nil for responses it does not recognize.
+type ErrorDecoder = requestkit.ErrorDecoder
+
+// RequestOptions configure a RequestClient or a single Send call.
+type RequestOptions func(*requestOptions)
+
+type requestOptions struct {
+	timeout  time.Duration
+	attempts int
+	backoff  func(attempt int) time.Duration
+	breaker  *breaker.Breaker
+	decoders []ErrorDecoder
+}
+
+// WithRequestTimeout sets how long to wait for each reply.
+func WithRequestTimeout(d time.Duration) RequestOptions {
+	return func(o *requestOptions) {
+		o.timeout = d
+	}
+}
+
+// WithIdempotentRetry resends a request that timed out, up to attempts times
+// in total, waiting backoff(n) before retry n (no wait when nil). Only use it
+// for requests that are safe to handle more than once; other failures, and
+// requests whose context is done, are never retried.
+func WithIdempotentRetry(attempts int, backoff func(attempt int) time.Duration) RequestOptions {
+	return func(o *requestOptions) {
+		o.attempts = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithRequestBreaker fails requests fast with breaker.ErrCircuitOpen while b
+// is open. Transport failures and request timeouts count against b; error
+// responses do not, since the handler was reachable, and neither do requests
+// abandoned because the caller's context is done. Share b between clients that talk
+// to the same service.
+func WithRequestBreaker(b *breaker.Breaker) RequestOptions {
+	return func(o *requestOptions) {
+		o.breaker = b
+	}
+}
+
+// WithErrorDecoder adds a decoder for service-specific error responses. It
+// runs before the built-in decoding of messaging.ErrorResponse.
+func WithErrorDecoder(decoder ErrorDecoder) RequestOptions {
+	return func(o *requestOptions) {
+		o.decoders = append(o.decoders, decoder)
+	}
+}
+
+// RequestClient sends requests of type TReq and returns their TResp replies,
+// turning error responses into errors. Declare one per request type:
+//
+//	var getUser = messaging.NewRequestClient[*GetUser, *User](factory,
+//		messaging.WithRequestTimeout(2*time.Second),
+//		messaging.WithIdempotentRetry(3, nil))
+//
+//	user, err := getUser.Send(ctx, &GetUser{ID: id})
+//
+// It is safe for concurrent use.
+type RequestClient[TReq messaging.Request, TResp messaging.Response] struct {
+	factory messaging.MessageBusFactory
+	opts    requestOptions
+}
+
+// NewRequestClient creates a client sending requests over buses from factory.
+func NewRequestClient[TReq messaging.Request, TResp messaging.Response](factory messaging.MessageBusFactory, opts ...RequestOptions) *RequestClient[TReq, TResp] {
+	c := &RequestClient[TReq, TResp]{factory: factory, opts: requestOptions{timeout: DefaultRequestTimeout}}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	return c
+}
+
+// Send sends req and waits for its reply. opts override the client's options
+// for this call only.
+func (c *RequestClient[TReq, TResp]) Send(ctx context.Context, req TReq, opts ...RequestOptions) (TResp, error) {
+	o := c.opts
+	o.decoders = slices.Clip(o.decoders)
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.timeout <= 0 {
+		o.timeout = DefaultRequestTimeout
+	}
+
+	var zero TResp
+	bus, err := c.factory.Get(ctx)
+	if err != nil {
+		return zero, fmt.Errorf("failed to get message bus: %w", err)
+	}
+
+	attempts := max(o.attempts, 1)
+	for attempt := 1; ; attempt++ {
+		resp, err := sendOnce[TResp](ctx, bus, req, &o)
+		if err == nil || attempt >= attempts || !errors.Is(err, ErrRequestTimeout) {
+			return resp, err
+		}
+		if o.backoff != nil {
+			if waitErr := sleepContext(ctx, o.backoff(attempt)); waitErr != nil {
+				return zero, errors.Join(err, waitErr)
+			}
+		}
+	}
+}
+
+func sendOnce[TResp messaging.Response](ctx context.Context, bus messaging.MessageBus, req messaging.Request, o *requestOptions) (TResp, error) {
+	return requestkit.Send[TResp](ctx, bus, req, requestkit.Options{
+		Timeout:  o.timeout,
+		Breaker:  o.breaker,
+		Decoders: o.decoders,
+	})
+}
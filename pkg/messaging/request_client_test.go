@@ -0,0 +1,217 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/breaker"
+	"github.com/hydn-co/mesh-sdk/pkg/testkit"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func expectRequest(bus *testkit.MockMessageBus, resp messaging.Response, err error) *mock.Call {
+	return bus.Mock.On("RequestWithContext", mock.Anything, mock.Anything, mock.Anything).Return(resp, err).Once()
+}
+
+func TestShouldReturnTypedResponse(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	bus.Mock.On("RequestWithContext", mock.Anything, mock.Anything, 2*time.Second).Return(&messaging.Accepted{}, nil).Once()
+	client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus), WithRequestTimeout(time.Minute))
+
+	// Act
+	resp, err := client.Send(context.Background(), &testRequest{TenantID: uuid.New()}, WithRequestTimeout(2*time.Second))
+
+	// Assert
+	require.NoError(t, err)
+	assert.NotNil(t, resp)
+	bus.AssertExpectations(t)
+}
+
+func TestShouldDecodeErrorResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		resp   messaging.Response
+		opts   []RequestOptions
+		assert func(t *testing.T, err error)
+	}{
+		{
+			name: "error response",
+			resp: &messaging.ErrorResponse{Error: "boom"},
+			assert: func(t *testing.T, err error) {
+				var remote *RemoteError
+				require.ErrorAs(t, err, &remote)
+				assert.Equal(t, "boom", remote.Message)
+				assert.Equal(t, "mesh://test/request", remote.Discriminator)
+			},
+		},
+		{
+			name: "forbidden",
+			resp: &messaging.ErrorResponse{Error: "forbidden: missing role \"admin\""},
+			assert: func(t *testing.T, err error) {
+				require.ErrorIs(t, err, ErrForbidden)
+				assert.Equal(t, "forbidden: missing role \"admin\"", err.Error())
+			},
+		},
+		{
+			name: "custom decoder",
+			resp: &messaging.ErrorResponse{Error: "not found"},
+			opts: []RequestOptions{WithErrorDecoder(func(resp messaging.Response) error {
+				if r, ok := resp.(*messaging.ErrorResponse); ok && r.Error == "not found" {
+					return errNotFound
+				}
+				return nil
+			})},
+			assert: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, errNotFound)
+			},
+		},
+		{
+			name: "unexpected type",
+			resp: &testRequest{},
+			assert: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "unexpected response type")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			bus := testkit.NewMockMessageBus()
+			expectRequest(bus, tt.resp, nil)
+			client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus), tt.opts...)
+
+			// Act
+			_, err := client.Send(context.Background(), &testRequest{})
+
+			// Assert
+			tt.assert(t, err)
+		})
+	}
+}
+
+var errNotFound = errors.New("not found")
+
+func TestShouldRetryIdempotentRequestsOnTimeout(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	expectRequest(bus, &messaging.Accepted{}, nats.ErrTimeout)
+	expectRequest(bus, &messaging.Accepted{}, context.DeadlineExceeded)
+	expectRequest(bus, &messaging.Accepted{}, nil)
+	client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus), WithIdempotentRetry(3, nil))
+
+	// Act
+	resp, err := client.Send(context.Background(), &testRequest{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.NotNil(t, resp)
+	bus.AssertExpectations(t)
+}
+
+func TestShouldNotRetryRequestsByDefault(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	expectRequest(bus, &messaging.Accepted{}, nats.ErrTimeout)
+	client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus))
+
+	// Act
+	_, err := client.Send(context.Background(), &testRequest{})
+
+	// Assert
+	require.ErrorIs(t, err, ErrRequestTimeout)
+	assert.ErrorIs(t, err, nats.ErrTimeout)
+	bus.AssertNumberOfCalls(t, "RequestWithContext", 1)
+}
+
+func TestShouldNotRetryFailuresOtherThanTimeouts(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	expectRequest(bus, &messaging.Accepted{}, nats.ErrNoResponders)
+	client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus), WithIdempotentRetry(3, nil))
+
+	// Act
+	_, err := client.Send(context.Background(), &testRequest{})
+
+	// Assert
+	require.ErrorIs(t, err, nats.ErrNoResponders)
+	bus.AssertNumberOfCalls(t, "RequestWithContext", 1)
+}
+
+func TestShouldFailFastWhileBreakerIsOpen(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	expectRequest(bus, &messaging.Accepted{}, nats.ErrNoResponders)
+	b := breaker.New(breaker.WithFailureThreshold(1), breaker.WithCooldown(time.Hour))
+	client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus), WithRequestBreaker(b))
+	_, err := client.Send(context.Background(), &testRequest{})
+	require.Error(t, err)
+
+	// Act
+	_, err = client.Send(context.Background(), &testRequest{})
+
+	// Assert
+	require.ErrorIs(t, err, breaker.ErrCircuitOpen)
+	bus.AssertNumberOfCalls(t, "RequestWithContext", 1)
+}
+
+func TestShouldNotTripBreakerOnErrorResponses(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	expectRequest(bus, &messaging.ErrorResponse{Error: "boom"}, nil)
+	expectRequest(bus, &messaging.Accepted{}, nil)
+	b := breaker.New(breaker.WithFailureThreshold(1))
+	client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus), WithRequestBreaker(b))
+	_, err := client.Send(context.Background(), &testRequest{})
+	require.Error(t, err)
+
+	// Act
+	_, err = client.Send(context.Background(), &testRequest{})
+
+	// Assert
+	require.NoError(t, err)
+}
+
+func TestShouldNotRetryOrTripBreakerWhenCallerDeadlineExpires(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	bus := testkit.NewMockMessageBus()
+	expectRequest(bus, &messaging.Accepted{}, context.DeadlineExceeded)
+	b := breaker.New(breaker.WithFailureThreshold(1))
+	client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus),
+		WithIdempotentRetry(3, nil), WithRequestBreaker(b))
+
+	// Act
+	_, err := client.Send(ctx, &testRequest{})
+
+	// Assert
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrRequestTimeout)
+	bus.AssertNumberOfCalls(t, "RequestWithContext", 1)
+	assert.Equal(t, breaker.Closed, b.State())
+}
+
+func TestShouldTripBreakerOnRequestTimeout(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	expectRequest(bus, &messaging.Accepted{}, context.DeadlineExceeded)
+	b := breaker.New(breaker.WithFailureThreshold(1), breaker.WithCooldown(time.Hour))
+	client := NewRequestClient[*testRequest, *messaging.Accepted](testkit.ConfigureBusFactory(bus), WithRequestBreaker(b))
+
+	// Act
+	_, err := client.Send(context.Background(), &testRequest{})
+
+	// Assert
+	require.ErrorIs(t, err, ErrRequestTimeout)
+	assert.Equal(t, breaker.Open, b.State())
+}
@@ -0,0 +1,167 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/messaging/memstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memConsumer runs a StreamProcessorBase against an in-memory stream.
+type memConsumer struct {
+	stream   *memstream.Client
+	storeID  uuid.UUID
+	p        *StreamProcessorBase
+	recorder *flushRecorder
+
+	mu      sync.Mutex
+	handled []string
+}
+
+func newMemConsumer(t *testing.T, handle func(value string) error) *memConsumer {
+	t.Helper()
+	c := &memConsumer{stream: memstream.New(), storeID: uuid.New(), recorder: &flushRecorder{}}
+	c.p = NewStreamProcessorBase(c.stream, c.storeID)
+	require.NoError(t, RegisterStreamHandler(c.p, func(ctx context.Context, e *testEvent) error {
+		if handle != nil {
+			if err := handle(e.Value); err != nil {
+				return err
+			}
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.handled = append(c.handled, e.Value)
+		return nil
+	}))
+	return c
+}
+
+func (c *memConsumer) append(t *testing.T, values ...string) []*streamkit.Entry {
+	t.Helper()
+	records := make([]*streamkit.Record, len(values))
+	for i, value := range values {
+		payload, err := polymorphic.MarshalPolymorphicJSON(&testEvent{Value: value})
+		require.NoError(t, err)
+		records[i] = &streamkit.Record{Payload: payload}
+	}
+	return c.stream.Append(c.storeID, "test-space", "segment", records...)
+}
+
+func (c *memConsumer) start(t *testing.T, opts ...ConsumerOptions) {
+	t.Helper()
+	opts = append([]ConsumerOptions{WithFlushHook(c.recorder.flush)}, opts...)
+	require.NoError(t, c.p.StartConsumer(context.Background(), opts...))
+	t.Cleanup(func() { _ = c.p.Stop(context.Background()) })
+}
+
+func (c *memConsumer) values() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.handled...)
+}
+
+func (c *memConsumer) flushed() []*ConsumerOffset {
+	c.recorder.mu.Lock()
+	defer c.recorder.mu.Unlock()
+	return append([]*ConsumerOffset(nil), c.recorder.offsets...)
+}
+
+func TestShouldConsumeEntriesAndFlushOffsetsInBatches(t *testing.T) {
+	// Arrange
+	c := newMemConsumer(t, nil)
+	entries := c.append(t, "a", "b", "c")
+
+	// Act
+	c.start(t, WithBatchSize(2))
+	require.Eventually(t, func() bool { return len(c.flushed()) >= 2 }, time.Second, time.Millisecond)
+	require.NoError(t, c.p.Stop(context.Background()))
+
+	// Assert
+	assert.Equal(t, []string{"a", "b", "c"}, c.values())
+	flushed := c.flushed()
+	require.Len(t, flushed, 2)
+	assert.Equal(t, entries[1].GetSpaceOffset(), flushed[0].Offsets["test-space"])
+	assert.Equal(t, entries[2].GetSpaceOffset(), flushed[1].Offsets["test-space"])
+}
+
+func TestShouldResumeFromLoadedOffset(t *testing.T) {
+	// Arrange
+	c := newMemConsumer(t, nil)
+	entries := c.append(t, "a", "b", "c")
+	c.p.RegisterOffsetLoader(func(context.Context) (*ConsumerOffset, error) {
+		return &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"test-space": entries[0].GetSpaceOffset()}}, nil
+	})
+
+	// Act
+	c.start(t)
+
+	// Assert
+	require.Eventually(t, func() bool { return len(c.values()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"b", "c"}, c.values())
+}
+
+func TestShouldConsumeEntriesAnnouncedBySegmentNotifications(t *testing.T) {
+	// Arrange
+	c := newMemConsumer(t, nil)
+	c.start(t, WithIdleStrategy(IdleStrategy{PollInterval: time.Hour}))
+
+	// Act
+	c.append(t, "a")
+	require.Eventually(t, func() bool { return len(c.values()) == 1 }, time.Second, time.Millisecond)
+	c.append(t, "b")
+
+	// Assert
+	require.Eventually(t, func() bool { return len(c.values()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(0), c.p.MissedNotifications())
+}
+
+func TestShouldStopCommittingAtFailedEntry(t *testing.T) {
+	// Arrange
+	c := newMemConsumer(t, func(value string) error {
+		if value == "b" {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+	entries := c.append(t, "a", "b", "c")
+
+	// Act
+	c.start(t, WithBatchSize(1))
+	require.Eventually(t, func() bool { return c.p.ErrorCount() > 0 }, time.Second, time.Millisecond)
+	require.NoError(t, c.p.Stop(context.Background()))
+
+	// Assert
+	assert.Equal(t, []string{"a"}, c.values())
+	flushed := c.flushed()
+	require.NotEmpty(t, flushed)
+	assert.Equal(t, entries[0].GetSpaceOffset(), flushed[len(flushed)-1].Offsets["test-space"])
+	var cerr *ConsumerError
+	require.ErrorAs(t, c.p.LastError(), &cerr)
+	assert.Equal(t, entries[1].GetSpaceOffset(), cerr.Offset)
+}
+
+func TestShouldRecoverAfterConsumeErrors(t *testing.T) {
+	// Arrange
+	c := newMemConsumer(t, nil)
+	boom := errors.New("stream unavailable")
+	c.stream.FailConsume(boom)
+	c.append(t, "a")
+
+	// Act
+	c.start(t, WithIdleStrategy(IdleStrategy{PollInterval: 5 * time.Millisecond}))
+	require.Eventually(t, func() bool { return c.p.ErrorCount() > 0 }, time.Second, time.Millisecond)
+	c.stream.FailConsume(nil)
+
+	// Assert
+	assert.ErrorIs(t, c.p.LastError(), boom)
+	require.Eventually(t, func() bool { return len(c.values()) == 1 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,137 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+)
+
+// ConsumerError describes a failure in the stream consumer. Space,
+// Discriminator and Offset are set when the failure concerns a single entry.
+type ConsumerError struct {
+	StoreID       uuid.UUID
+	Space         string
+	Discriminator string
+	Offset        lexkey.LexKey
+	Err           error
+}
+
+func (e *ConsumerError) Error() string {
+	if e.Space == "" {
+		return fmt.Sprintf("stream consumer for store %s: %v", e.StoreID, e.Err)
+	}
+	return fmt.Sprintf("stream consumer for store %s, space %q: %v", e.StoreID, e.Space, e.Err)
+}
+
+func (e *ConsumerError) Unwrap() error {
+	return e.Err
+}
+
+// ConsumerErrorHandler is called for every error reported by the consumer.
+// It runs on the consumer goroutine and should return quickly.
+type ConsumerErrorHandler = func(ctx context.Context, err *ConsumerError)
+
+// WithLogger sets the logger used by the stream consumer. The default is
+// slog.Default().
+func WithLogger(logger *slog.Logger) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.logger = logger
+	}
+}
+
+// WithErrorHandler registers a hook called for every consumer error.
+func WithErrorHandler(handler ConsumerErrorHandler) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.onError = handler
+	}
+}
+
+// errorStats records the consumer's error history.
+type errorStats struct {
+	mu     sync.Mutex
+	last   error
+	lastAt time.Time
+	count  uint64
+}
+
+// LastError returns the most recent consumer error, or nil.
+func (p *StreamProcessorBase) LastError() error {
+	p.errStats.mu.Lock()
+	defer p.errStats.mu.Unlock()
+	return p.errStats.last
+}
+
+// ErrorCount returns the number of errors the consumer has reported.
+func (p *StreamProcessorBase) ErrorCount() uint64 {
+	p.errStats.mu.Lock()
+	defer p.errStats.mu.Unlock()
+	return p.errStats.count
+}
+
+// log returns the consumer logger tagged with the store ID.
+func (p *StreamProcessorBase) log() *slog.Logger {
+	logger := p.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With("store_id", p.storeID.String())
+}
+
+// reportError records err, logs it and calls the error hook. Cancellation
+// caused by the consumer stopping is not an error and is ignored.
+func (p *StreamProcessorBase) reportError(ctx context.Context, cerr *ConsumerError) {
+	cerr.StoreID = p.storeID
+	if ctx.Err() != nil && errors.Is(cerr.Err, ctx.Err()) {
+		return
+	}
+
+	p.errStats.mu.Lock()
+	p.errStats.last = cerr
+	p.errStats.lastAt = time.Now().UTC()
+	p.errStats.count++
+	p.errStats.mu.Unlock()
+
+	attrs := []any{"error", cerr.Err}
+	if cerr.Space != "" {
+		attrs = append(attrs,
+			"space", cerr.Space,
+			"discriminator", cerr.Discriminator,
+			"offset", cerr.Offset.ToHexString())
+	}
+	p.log().ErrorContext(ctx, "stream consumer error", attrs...)
+
+	if p.onError != nil {
+		p.onError(ctx, cerr)
+	}
+}
+
+// reportEntryError reports err as a failure handling entry and returns it
+// wrapped in a *ConsumerError.
+func (p *StreamProcessorBase) reportEntryError(ctx context.Context, entry *streamkit.Entry, err error) error {
+	discriminator, _ := peekDiscriminator(entry.Payload)
+	cerr := &ConsumerError{
+		Space:         entry.Space,
+		Discriminator: discriminator,
+		Offset:        entry.GetSpaceOffset(),
+		Err:           err,
+	}
+	p.reportError(ctx, cerr)
+	return cerr
+}
+
+// reportPassError reports an error that ended a consume pass unless it was
+// already reported for a single entry.
+func (p *StreamProcessorBase) reportPassError(ctx context.Context, err error) {
+	var cerr *ConsumerError
+	if err == nil || errors.As(err, &cerr) {
+		return
+	}
+	p.reportError(ctx, &ConsumerError{Err: err})
+}
@@ -0,0 +1,69 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldReportEntryErrorToHookAndStats(t *testing.T) {
+	// Arrange
+	storeID := uuid.New()
+	p := NewStreamProcessorBase(nil, storeID)
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		return errors.New("handler failed")
+	}))
+	var reported []*ConsumerError
+	var logs bytes.Buffer
+	WithErrorHandler(func(ctx context.Context, err *ConsumerError) { reported = append(reported, err) })(p)
+	WithLogger(slog.New(slog.NewJSONHandler(&logs, nil)))(p)
+	entry := newTestEntry(t, 4, "a")
+
+	// Act
+	err := p.processEntry(context.Background(), entry)
+
+	// Assert
+	var cerr *ConsumerError
+	require.ErrorAs(t, err, &cerr)
+	require.Len(t, reported, 1)
+	assert.Equal(t, storeID, reported[0].StoreID)
+	assert.Equal(t, "test-space", reported[0].Space)
+	assert.Equal(t, "mesh://test/event", reported[0].Discriminator)
+	assert.Equal(t, entry.GetSpaceOffset(), reported[0].Offset)
+	assert.Equal(t, uint64(1), p.ErrorCount())
+	assert.ErrorContains(t, p.LastError(), "handler failed")
+	assert.Contains(t, logs.String(), storeID.String())
+	assert.Contains(t, logs.String(), `"discriminator":"mesh://test/event"`)
+}
+
+func TestShouldNotReportCancellationWhileStopping(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	p.reportPassError(ctx, context.Canceled)
+
+	// Assert
+	assert.Zero(t, p.ErrorCount())
+	assert.NoError(t, p.LastError())
+}
+
+func TestShouldNotReportEntryErrorTwiceForPass(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.New())
+	err := p.reportEntryError(context.Background(), newTestEntry(t, 1, "a"), errors.New("boom"))
+
+	// Act
+	p.reportPassError(context.Background(), err)
+
+	// Assert
+	assert.Equal(t, uint64(1), p.ErrorCount())
+}
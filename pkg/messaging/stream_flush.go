@@ -0,0 +1,77 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultFinalFlushTimeout bounds the offset flush performed when the
+// consumer stops.
+const DefaultFinalFlushTimeout = 5 * time.Second
+
+// WithFlushInterval flushes committed offsets once d has passed since the
+// last flush, in addition to WithBatchSize; whichever comes first wins.
+// Offsets are also flushed on the interval while the consumer is idle.
+func WithFlushInterval(d time.Duration) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.flushInterval = d
+	}
+}
+
+// WithFinalFlushTimeout bounds the flush of pending offsets when the consumer
+// stops. The default is DefaultFinalFlushTimeout.
+func WithFinalFlushTimeout(d time.Duration) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.finalFlushTimeout = d
+	}
+}
+
+// flushIntervalElapsed reports whether the flush interval has passed. The
+// caller must hold offsetMu.
+func (p *StreamProcessorBase) flushIntervalElapsed() bool {
+	return p.flushInterval > 0 && time.Since(p.lastFlush) >= p.flushInterval
+}
+
+// flushOnInterval flushes pending offsets on the flush interval until ctx is
+// done, so offsets committed before a quiet period are not held back.
+func (p *StreamProcessorBase) flushOnInterval(ctx context.Context) {
+	if p.flushInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.offsetMu.Lock()
+			due := p.pending > 0 && p.flushIntervalElapsed()
+			p.offsetMu.Unlock()
+			if !due {
+				continue
+			}
+			if err := p.flushPending(ctx); err != nil {
+				p.reportPassError(ctx, fmt.Errorf("failed to flush offsets: %w", err))
+			}
+		}
+	}
+}
+
+// finalFlush flushes offsets still pending after the consumer exits. It uses
+// a context detached from the consumer's cancellation and bounded by the
+// final flush timeout.
+func (p *StreamProcessorBase) finalFlush(ctx context.Context) {
+	timeout := p.finalFlushTimeout
+	if timeout <= 0 {
+		timeout = DefaultFinalFlushTimeout
+	}
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	if err := p.flushPending(flushCtx); err != nil {
+		p.reportError(flushCtx, &ConsumerError{Err: fmt.Errorf("final offset flush failed: %w", err)})
+	}
+}
@@ -0,0 +1,110 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/lexkey"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type flushRecorder struct {
+	mu      sync.Mutex
+	offsets []*ConsumerOffset
+	ctxErrs []error
+}
+
+func (r *flushRecorder) flush(ctx context.Context, off *ConsumerOffset) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offsets = append(r.offsets, off)
+	r.ctxErrs = append(r.ctxErrs, ctx.Err())
+	return nil
+}
+
+func (r *flushRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.offsets)
+}
+
+func newFlushingProcessor(recorder *flushRecorder, opts ...ConsumerOptions) *StreamProcessorBase {
+	p := NewStreamProcessorBase(nil, uuid.New())
+	p.offset = &ConsumerOffset{Offsets: map[string]lexkey.LexKey{}}
+	p.lastFlush = time.Now()
+	WithFlushHook(recorder.flush)(p)
+	WithBatchSize(100)(p)
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func TestShouldFlushWhenIntervalElapsesBeforeBatchFills(t *testing.T) {
+	// Arrange
+	recorder := &flushRecorder{}
+	p := newFlushingProcessor(recorder, WithFlushInterval(10*time.Millisecond))
+	require.NoError(t, p.commitOffset(context.Background(), "space", lexkey.Encode("space", 1), 1))
+	require.Equal(t, 0, recorder.count())
+	time.Sleep(15 * time.Millisecond)
+
+	// Act
+	err := p.commitOffset(context.Background(), "space", lexkey.Encode("space", 2), 1)
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, 1, recorder.count())
+	assert.Equal(t, lexkey.Encode("space", 2), recorder.offsets[0].Offsets["space"])
+}
+
+func TestShouldFlushPendingOffsetsWhileIdle(t *testing.T) {
+	// Arrange
+	recorder := &flushRecorder{}
+	p := newFlushingProcessor(recorder, WithFlushInterval(10*time.Millisecond))
+	require.NoError(t, p.commitOffset(context.Background(), "space", lexkey.Encode("space", 1), 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Act
+	go p.flushOnInterval(ctx)
+
+	// Assert
+	require.Eventually(t, func() bool { return recorder.count() == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestShouldNotFlushWithCanceledContext(t *testing.T) {
+	// Arrange
+	recorder := &flushRecorder{}
+	p := newFlushingProcessor(recorder)
+	require.NoError(t, p.commitOffset(context.Background(), "space", lexkey.Encode("space", 1), 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	err := p.flushPending(ctx)
+
+	// Assert
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, recorder.count())
+}
+
+func TestShouldFlushPendingOffsetsOnStopWithLiveContext(t *testing.T) {
+	// Arrange
+	recorder := &flushRecorder{}
+	p := newFlushingProcessor(recorder)
+	require.NoError(t, p.commitOffset(context.Background(), "space", lexkey.Encode("space", 1), 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	p.finalFlush(ctx)
+
+	// Assert
+	require.Equal(t, 1, recorder.count())
+	assert.NoError(t, recorder.ctxErrs[0])
+	assert.Equal(t, lexkey.Encode("space", 1), recorder.offsets[0].Offsets["space"])
+}
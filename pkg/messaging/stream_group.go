@@ -0,0 +1,357 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fgrzl/lexkey"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/leasekit"
+	"github.com/hydn-co/mesh-sdk/pkg/meshctx"
+)
+
+// DefaultGroupLeaseTTL is the lease TTL used by consumer groups when none is
+// configured.
+const DefaultGroupLeaseTTL = 30 * time.Second
+
+// ErrLeaseLost is wrapped by errors reported when a consumer group member
+// could not renew its lease before it expired.
+var ErrLeaseLost = errors.New("consumer group lease lost")
+
+// ConsumerGroup configures competing consumers. Replicas sharing Name
+// coordinate through leasekit leases so that only the lease holder consumes,
+// either the whole processor or, with PerSpace, each space independently.
+// Offsets are kept in Store, which must be shared by every replica (for
+// example a StreamOffsetStore), so a new holder resumes where the previous
+// one stopped.
+type ConsumerGroup struct {
+	Leases leasekit.Client
+	Store  OffsetStore
+	// Name identifies the group. It is the lease key and the offset store
+	// consumer name; per-space groups append "/<space>" to both.
+	Name string
+	// PerSpace leases each space separately so spaces are spread across
+	// replicas.
+	PerSpace bool
+	// TenantID scopes the leases. When nil the tenant in the consumer's
+	// context (meshctx) is used.
+	TenantID uuid.UUID
+	// TTL is the lease TTL; leases are renewed every TTL/3, and failed
+	// renewals are retried until the lease expires.
+	TTL time.Duration
+	// RetryInterval is the wait between attempts to acquire a lease held by
+	// another replica. The default is TTL/3.
+	RetryInterval time.Duration
+}
+
+// WithConsumerGroup runs the consumer as a member of group and stores its
+// offsets in group.Store, replacing any registered offset loader and flush
+// hook.
+func WithConsumerGroup(group ConsumerGroup) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		g := group
+		if g.TTL <= 0 {
+			g.TTL = DefaultGroupLeaseTTL
+		}
+		if g.RetryInterval <= 0 {
+			g.RetryInterval = g.TTL / 3
+		}
+		state := &groupState{ConsumerGroup: g, held: make(map[string]context.Context)}
+		p.group = state
+		if g.PerSpace {
+			p.loadOffset = p.loadGroupSpaces
+			p.flushOffset = p.saveGroupSpaces
+			return
+		}
+		WithOffsetStore(g.Store, g.Name)(p)
+		save := p.flushOffset
+		p.flushOffset = func(ctx context.Context, offset *ConsumerOffset) error {
+			// only the lease holder may move the group's offset
+			if !state.holds(g.Name) {
+				return nil
+			}
+			return save(ctx, offset)
+		}
+	}
+}
+
+// groupState is a ConsumerGroup plus the leases this replica currently holds.
+type groupState struct {
+	ConsumerGroup
+
+	mu sync.Mutex
+	// held maps each lease key held to the lease's context, which is
+	// canceled with ErrLeaseLost when the lease expires unrenewed
+	held map[string]context.Context
+}
+
+func (g *groupState) hold(key string, leaseCtx context.Context) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.held[key] = leaseCtx
+}
+
+// drop forgets key and reports whether any lease is still held.
+func (g *groupState) drop(key string) (holdsAny bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.held, key)
+	return len(g.held) > 0
+}
+
+// holds reports whether this replica holds key and has not lost it.
+func (g *groupState) holds(key string) bool {
+	g.mu.Lock()
+	leaseCtx, ok := g.held[key]
+	g.mu.Unlock()
+	return ok && !leaseLost(leaseCtx)
+}
+
+// leaseLost reports whether ctx was canceled because its lease was lost.
+func leaseLost(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrLeaseLost)
+}
+
+func (g *groupState) spaceKey(space string) string {
+	return g.Name + "/" + space
+}
+
+func (g *groupState) tenant(ctx context.Context) uuid.UUID {
+	if g.TenantID != uuid.Nil {
+		return g.TenantID
+	}
+	if tenantID, err := meshctx.TenantIDFromContext(ctx); err == nil {
+		return tenantID
+	}
+	return uuid.Nil
+}
+
+// runLeader runs the consumer whenever this replica holds the group lease.
+func (p *StreamProcessorBase) runLeader(ctx context.Context) error {
+	g := p.group
+	for {
+		acquired, runErr, lostErr := p.runWithLease(ctx, g.Name, func(ctx context.Context) error {
+			g.hold(g.Name, ctx)
+			defer g.drop(g.Name)
+			p.setState(ConsumerRunning)
+			err := p.runConsumer(ctx)
+			// flush while still holding the lease so the next holder
+			// starts from our final position; once it is lost the next
+			// holder may already be ahead, so pending offsets are dropped
+			if !leaseLost(ctx) {
+				p.finalFlush(ctx)
+			}
+			return err
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch {
+		case !acquired:
+			p.setState(ConsumerStandby)
+			p.log().DebugContext(ctx, "waiting for consumer group lease", "group", g.Name, "error", runErr)
+		case lostErr != nil:
+			p.setState(ConsumerStandby)
+			p.log().WarnContext(ctx, "consumer group lease lost", "group", g.Name, "error", lostErr)
+		default:
+			return runErr
+		}
+
+		if err := sleepContext(ctx, g.RetryInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// runGroupSpaces competes for each space's lease independently and consumes
+// the spaces it wins. It returns when the first space fails.
+func (p *StreamProcessorBase) runGroupSpaces(ctx context.Context, spaces []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(spaces))
+	var wg sync.WaitGroup
+	for _, space := range spaces {
+		wg.Add(1)
+		go func(space string) {
+			defer wg.Done()
+			errs <- p.runGroupSpace(ctx, space)
+		}(space)
+	}
+
+	err := <-errs
+	cancel()
+	wg.Wait()
+	return err
+}
+
+func (p *StreamProcessorBase) runGroupSpace(ctx context.Context, space string) error {
+	g := p.group
+	for {
+		acquired, runErr, lostErr := p.runWithLease(ctx, g.spaceKey(space), func(ctx context.Context) error {
+			if err := p.claimSpace(ctx, space); err != nil {
+				return err
+			}
+			defer p.releaseSpace(ctx, space)
+			return p.consumeLoop(ctx, []string{space}, p.consumeSequential)
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch {
+		case !acquired:
+			p.log().DebugContext(ctx, "waiting for consumer group lease", "group", g.Name, "space", space, "error", runErr)
+		case lostErr != nil:
+			p.log().WarnContext(ctx, "consumer group lease lost", "group", g.Name, "space", space, "error", lostErr)
+		default:
+			return runErr
+		}
+
+		if err := sleepContext(ctx, g.RetryInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// claimSpace marks space as owned and resumes it from the shared store.
+func (p *StreamProcessorBase) claimSpace(ctx context.Context, space string) error {
+	g := p.group
+	stored, err := g.Store.Load(ctx, g.spaceKey(space))
+	if err != nil {
+		return fmt.Errorf("failed to load offset for space %q: %w", space, err)
+	}
+	if stored != nil {
+		if key, ok := stored.Offsets[space]; ok {
+			p.offsetMu.Lock()
+			p.offset.Offsets[space] = key
+			p.offsetMu.Unlock()
+		}
+	}
+
+	g.hold(g.spaceKey(space), ctx)
+	p.setState(ConsumerRunning)
+	p.log().InfoContext(ctx, "claimed stream space", "group", g.Name, "space", space)
+	return nil
+}
+
+// releaseSpace flushes pending offsets, unless the space's lease was lost,
+// and stops writing space's offset.
+func (p *StreamProcessorBase) releaseSpace(ctx context.Context, space string) {
+	if !leaseLost(ctx) {
+		p.finalFlush(ctx)
+	}
+	if !p.group.drop(p.group.spaceKey(space)) {
+		p.setState(ConsumerStandby)
+	}
+}
+
+// loadGroupSpaces combines the stored per-space offsets of every registered
+// space.
+func (p *StreamProcessorBase) loadGroupSpaces(ctx context.Context) (*ConsumerOffset, error) {
+	g := p.group
+	off := &ConsumerOffset{Offsets: make(map[string]lexkey.LexKey)}
+	for _, space := range p.spaces.ToSlice() {
+		stored, err := g.Store.Load(ctx, g.spaceKey(space))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load offset for space %q: %w", space, err)
+		}
+		if stored == nil {
+			continue
+		}
+		if key, ok := stored.Offsets[space]; ok {
+			off.Offsets[space] = key
+		}
+	}
+	if len(off.Offsets) == 0 {
+		return nil, nil
+	}
+	return off, nil
+}
+
+// saveGroupSpaces saves the offsets of spaces whose lease is held, each
+// under its own key, so replicas owning different spaces never overwrite
+// each other.
+func (p *StreamProcessorBase) saveGroupSpaces(ctx context.Context, off *ConsumerOffset) error {
+	g := p.group
+	var errs []error
+	for space, key := range off.Offsets {
+		if !g.holds(g.spaceKey(space)) {
+			continue
+		}
+		single := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{space: key}}
+		if err := g.Store.Save(ctx, g.spaceKey(space), single); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save offset for space %q: %w", space, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runWithLease acquires key and runs fn while renewing it every TTL/3. A
+// failed renewal is retried every TTL/10 until the lease expires; only then
+// is fn's context canceled with a cause wrapping ErrLeaseLost. The lease is
+// released after fn returns, never before, and on a best-effort basis once
+// lost. acquired reports whether fn ran; when it did not, runErr holds the
+// acquisition error. lostErr is set when the lease expired unrenewed.
+func (p *StreamProcessorBase) runWithLease(
+	ctx context.Context,
+	key string,
+	fn func(context.Context) error,
+) (acquired bool, runErr error, lostErr error) {
+	g := p.group
+	lease, err := g.Leases.Acquire(ctx, g.tenant(ctx), key, g.TTL, 1)
+	if err != nil {
+		return false, err, nil
+	}
+	defer p.releaseLease(ctx, key, lease)
+
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(leaseCtx)
+	}()
+
+	renewEvery, retryEvery := g.TTL/3, max(g.TTL/10, time.Millisecond)
+	renew := time.NewTimer(renewEvery)
+	defer renew.Stop()
+
+	for {
+		select {
+		case runErr = <-done:
+			return true, runErr, nil
+
+		case <-renew.C:
+			err := g.Leases.Renew(ctx, lease)
+			switch {
+			case err == nil:
+				renew.Reset(renewEvery)
+			case ctx.Err() != nil:
+				// fn is stopping with ctx
+			case time.Until(lease.ExpireAt) > 0:
+				p.log().WarnContext(ctx, "failed to renew consumer group lease; retrying",
+					"key", key, "expire_at", lease.ExpireAt, "error", err)
+				renew.Reset(min(retryEvery, time.Until(lease.ExpireAt)))
+			default:
+				lostErr = fmt.Errorf("%w: %w", ErrLeaseLost, err)
+				cancel(lostErr)
+				<-done
+				return true, nil, lostErr
+			}
+		}
+	}
+}
+
+// releaseLease releases lease without waiting longer than a renewal period.
+// Failures are logged; an unreleased lease expires on its own.
+func (p *StreamProcessorBase) releaseLease(ctx context.Context, key string, lease *leasekit.Lease) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.group.TTL/3)
+	defer cancel()
+	if err := p.group.Leases.Release(ctx, lease); err != nil {
+		p.log().WarnContext(ctx, "failed to release consumer group lease", "key", key, "error", err)
+	}
+}
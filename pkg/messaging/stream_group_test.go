@@ -0,0 +1,292 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/lexkey"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/leasekit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errLeaseHeld = errors.New("lease held")
+
+// fakeLeases grants each key to one holder at a time.
+type fakeLeases struct {
+	mu            sync.Mutex
+	held          map[string]bool
+	renewErr      error
+	renewFailures int
+	released      []string
+}
+
+func newFakeLeases() *fakeLeases {
+	return &fakeLeases{held: make(map[string]bool)}
+}
+
+func (f *fakeLeases) Acquire(ctx context.Context, tenantID uuid.UUID, key string, ttl time.Duration, maxAttempts int) (*leasekit.Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.held[key] {
+		return nil, errLeaseHeld
+	}
+	f.held[key] = true
+	return &leasekit.Lease{ID: uuid.New(), TenantID: tenantID, Key: key, TTL: ttl, ExpireAt: time.Now().Add(ttl)}, nil
+}
+
+func (f *fakeLeases) Renew(ctx context.Context, lease *leasekit.Lease) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.renewFailures > 0 {
+		f.renewFailures--
+		return errors.New("renewal timed out")
+	}
+	if f.renewErr != nil {
+		return f.renewErr
+	}
+	lease.ExpireAt = time.Now().Add(lease.TTL)
+	return nil
+}
+
+func (f *fakeLeases) Release(ctx context.Context, lease *leasekit.Lease) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.held, lease.Key)
+	f.released = append(f.released, lease.Key)
+	return nil
+}
+
+func (f *fakeLeases) failRenewals(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.renewErr = err
+}
+
+// failNextRenewals makes the next n renewals fail.
+func (f *fakeLeases) failNextRenewals(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.renewFailures = n
+}
+
+func newGroupProcessor(leases leasekit.Client, store OffsetStore, perSpace bool) *StreamProcessorBase {
+	p := NewStreamProcessorBase(nil, uuid.New())
+	p.offset = &ConsumerOffset{Offsets: map[string]lexkey.LexKey{}}
+	WithConsumerGroup(ConsumerGroup{
+		Leases:   leases,
+		Store:    store,
+		Name:     "projection",
+		PerSpace: perSpace,
+		TTL:      30 * time.Millisecond,
+	})(p)
+	return p
+}
+
+func TestShouldNotRunWhenLeaseIsHeldElsewhere(t *testing.T) {
+	// Arrange
+	leases := newFakeLeases()
+	leases.held["projection"] = true
+	p := newGroupProcessor(leases, NewMemoryOffsetStore(), false)
+	ran := false
+
+	// Act
+	acquired, runErr, lostErr := p.runWithLease(context.Background(), "projection", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	// Assert
+	assert.False(t, acquired)
+	assert.False(t, ran)
+	assert.ErrorIs(t, runErr, errLeaseHeld)
+	assert.NoError(t, lostErr)
+}
+
+func TestShouldReleaseLeaseAfterRunReturns(t *testing.T) {
+	// Arrange
+	leases := newFakeLeases()
+	p := newGroupProcessor(leases, NewMemoryOffsetStore(), false)
+	var heldDuringRun bool
+
+	// Act
+	acquired, runErr, lostErr := p.runWithLease(context.Background(), "projection", func(ctx context.Context) error {
+		leases.mu.Lock()
+		heldDuringRun = leases.held["projection"]
+		leases.mu.Unlock()
+		return nil
+	})
+
+	// Assert
+	assert.True(t, acquired)
+	assert.NoError(t, runErr)
+	assert.NoError(t, lostErr)
+	assert.True(t, heldDuringRun)
+	assert.Equal(t, []string{"projection"}, leases.released)
+}
+
+func TestShouldCancelRunWhenLeaseIsLost(t *testing.T) {
+	// Arrange
+	leases := newFakeLeases()
+	leases.failRenewals(errors.New("expired"))
+	p := newGroupProcessor(leases, NewMemoryOffsetStore(), false)
+
+	start := time.Now()
+
+	// Act
+	acquired, runErr, lostErr := p.runWithLease(context.Background(), "projection", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// Assert
+	assert.True(t, acquired)
+	assert.NoError(t, runErr)
+	assert.ErrorIs(t, lostErr, ErrLeaseLost)
+	assert.GreaterOrEqual(t, time.Since(start), p.group.TTL, "the lease must be retried until it expires")
+	assert.Equal(t, []string{"projection"}, leases.released)
+}
+
+func TestShouldKeepLeaseThroughTransientRenewalFailures(t *testing.T) {
+	// Arrange
+	leases := newFakeLeases()
+	leases.failNextRenewals(2)
+	p := newGroupProcessor(leases, NewMemoryOffsetStore(), false)
+
+	// Act
+	acquired, runErr, lostErr := p.runWithLease(context.Background(), "projection", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * p.group.TTL):
+			return nil
+		}
+	})
+
+	// Assert
+	assert.True(t, acquired)
+	assert.NoError(t, runErr)
+	assert.NoError(t, lostErr)
+	assert.Equal(t, []string{"projection"}, leases.released)
+}
+
+func TestShouldResumeClaimedSpaceFromSharedStore(t *testing.T) {
+	// Arrange
+	store := NewMemoryOffsetStore()
+	previous := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"orders": lexkey.Encode("orders", 7)}}
+	require.NoError(t, store.Save(context.Background(), "projection/orders", previous))
+	p := newGroupProcessor(newFakeLeases(), store, true)
+
+	// Act
+	err := p.claimSpace(context.Background(), "orders")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, lexkey.Encode("orders", 7), p.offset.Offsets["orders"])
+}
+
+func TestShouldSaveOnlyOwnedSpacesUnderTheirOwnKeys(t *testing.T) {
+	// Arrange
+	store := NewMemoryOffsetStore()
+	p := newGroupProcessor(newFakeLeases(), store, true)
+	require.NoError(t, p.claimSpace(context.Background(), "orders"))
+	off := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{
+		"orders":   lexkey.Encode("orders", 3),
+		"invoices": lexkey.Encode("invoices", 9),
+	}}
+
+	// Act
+	err := p.flushOffset(context.Background(), off)
+
+	// Assert
+	require.NoError(t, err)
+	orders, err := store.Load(context.Background(), "projection/orders")
+	require.NoError(t, err)
+	assert.Equal(t, lexkey.Encode("orders", 3), orders.Offsets["orders"])
+	invoices, err := store.Load(context.Background(), "projection/invoices")
+	require.NoError(t, err)
+	assert.Nil(t, invoices)
+}
+
+func TestShouldReportStandbyWhileAnotherReplicaLeads(t *testing.T) {
+	// Arrange
+	leases := newFakeLeases()
+	leases.held["projection"] = true
+	p := newGroupProcessor(leases, NewMemoryOffsetStore(), false)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	// Act
+	go func() { done <- p.runLeader(ctx) }()
+
+	// Assert
+	assert.Eventually(t, func() bool { return p.Status().State == ConsumerStandby }, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+// lostLease returns a lease context whose lease has been lost.
+func lostLease() context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(ErrLeaseLost)
+	return ctx
+}
+
+func TestShouldNotSaveLeaderOffsetAfterLosingLease(t *testing.T) {
+	// Arrange
+	store := NewMemoryOffsetStore()
+	p := newGroupProcessor(newFakeLeases(), store, false)
+	leaseCtx, lose := context.WithCancelCause(context.Background())
+	p.group.hold("projection", leaseCtx)
+	saved := &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"orders": lexkey.Encode("orders", 3)}}
+	require.NoError(t, p.flushOffset(context.Background(), saved))
+	lose(ErrLeaseLost)
+
+	// Act
+	err := p.flushOffset(context.Background(), &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"orders": lexkey.Encode("orders", 1)}})
+
+	// Assert
+	require.NoError(t, err)
+	offset, err := store.Load(context.Background(), "projection")
+	require.NoError(t, err)
+	assert.Equal(t, lexkey.Encode("orders", 3), offset.Offsets["orders"])
+}
+
+func TestShouldNotFlushSpaceAfterLosingItsLease(t *testing.T) {
+	// Arrange
+	store := NewMemoryOffsetStore()
+	p := newGroupProcessor(newFakeLeases(), store, true)
+	leaseCtx := lostLease()
+	require.NoError(t, p.claimSpace(leaseCtx, "orders"))
+	p.offset.Offsets["orders"] = lexkey.Encode("orders", 3)
+	p.pending = 1
+
+	// Act
+	p.releaseSpace(leaseCtx, "orders")
+
+	// Assert
+	offset, err := store.Load(context.Background(), "projection/orders")
+	require.NoError(t, err)
+	assert.Nil(t, offset)
+	assert.Equal(t, ConsumerStandby, p.Status().State)
+}
+
+func TestShouldReportStandbyWhenStartedWhileAnotherReplicaLeads(t *testing.T) {
+	// Arrange
+	leases := newFakeLeases()
+	leases.held["projection"] = true
+	p := newGroupProcessor(leases, NewMemoryOffsetStore(), false)
+	p.RegisterSpaces("orders")
+
+	// Act
+	require.NoError(t, p.StartConsumer(context.Background()))
+	defer func() { require.NoError(t, p.Stop(context.Background())) }()
+
+	// Assert
+	assert.Equal(t, ConsumerStandby, p.Status().State)
+	assert.Never(t, func() bool { return p.Status().State == ConsumerRunning }, 100*time.Millisecond, 5*time.Millisecond)
+}
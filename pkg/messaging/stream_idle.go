@@ -0,0 +1,112 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/fgrzl/enumerators"
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+)
+
+// DefaultIdlePollInterval is the longest the consumer waits for a segment
+// notification before consuming again when no idle strategy is configured.
+const DefaultIdlePollInterval = 30 * time.Second
+
+// IdleStrategy controls how the consumer waits between passes. A pass
+// starts as soon as a segment notification arrives; without one the consumer
+// polls after PollInterval so a missed notification delays entries by at most
+// that long. When MaxPollInterval exceeds PollInterval, the wait doubles after
+// every pass that handled nothing, up to MaxPollInterval, and resets once
+// entries arrive.
+type IdleStrategy struct {
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+}
+
+// WithIdleStrategy sets how the consumer waits between passes.
+func WithIdleStrategy(s IdleStrategy) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.idle = s
+	}
+}
+
+// MissedNotifications returns how many polls found entries no segment
+// notification had announced.
+func (p *StreamProcessorBase) MissedNotifications() uint64 {
+	return p.missedNotifications.Load()
+}
+
+// idleWaits yields the wait before each poll.
+type idleWaits struct {
+	base time.Duration
+	max  time.Duration
+	next time.Duration
+}
+
+func (s IdleStrategy) waits() *idleWaits {
+	base := s.PollInterval
+	if base <= 0 {
+		base = DefaultIdlePollInterval
+	}
+	return &idleWaits{base: base, max: max(s.MaxPollInterval, base), next: base}
+}
+
+// after returns the wait following a pass and advances the backoff.
+func (w *idleWaits) after(progressed bool) time.Duration {
+	if progressed {
+		w.next = w.base
+		return w.next
+	}
+	wait := w.next
+	w.next = min(w.next*2, w.max)
+	return wait
+}
+
+// consumeLoop repeatedly consumes spaces from their committed offsets, waiting
+// for segment activity between passes as the idle strategy dictates. consume
+// handles a single pass.
+func (p *StreamProcessorBase) consumeLoop(
+	ctx context.Context,
+	spaces []string,
+	consume func(context.Context, enumerators.Enumerator[*streamkit.Entry]) error,
+) error {
+	sub := p.tickler.Subscribe(ctx, spaces...)
+	defer sub.Dispose()
+
+	waits := p.idle.waits()
+	notified := true
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		before := p.offsetsFor(spaces)
+		args := &streamkit.Consume{Offsets: before}
+		if err := consume(ctx, p.stream.Consume(ctx, p.storeID, args)); err != nil {
+			return err
+		}
+
+		progressed := !sameOffsets(before, p.offsetsFor(spaces))
+		if progressed && !notified {
+			p.missedNotifications.Add(1)
+			p.log().InfoContext(ctx, "poll found entries without a segment notification", "spaces", spaces)
+		}
+
+		// the subscription shares ctx, so Stop wakes the wait immediately
+		notified = sub.WaitTimeout(waits.after(progressed))
+	}
+}
+
+func sameOffsets(a, b map[string]lexkey.LexKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for space, key := range a {
+		if !bytes.Equal(key, b[space]) {
+			return false
+		}
+	}
+	return true
+}
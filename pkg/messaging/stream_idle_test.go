@@ -0,0 +1,53 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fgrzl/lexkey"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldBackOffIdlePollsUntilEntriesArrive(t *testing.T) {
+	// Arrange
+	waits := IdleStrategy{PollInterval: time.Second, MaxPollInterval: 4 * time.Second}.waits()
+
+	// Act
+	got := []time.Duration{
+		waits.after(false),
+		waits.after(false),
+		waits.after(false),
+		waits.after(false),
+		waits.after(true),
+		waits.after(false),
+	}
+
+	// Assert
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Second, time.Second,
+	}, got)
+}
+
+func TestShouldPollAtFixedIntervalByDefault(t *testing.T) {
+	// Arrange
+	waits := IdleStrategy{}.waits()
+
+	// Act
+	first := waits.after(false)
+	second := waits.after(false)
+
+	// Assert
+	assert.Equal(t, DefaultIdlePollInterval, first)
+	assert.Equal(t, DefaultIdlePollInterval, second)
+}
+
+func TestShouldDetectOffsetProgress(t *testing.T) {
+	// Arrange
+	before := map[string]lexkey.LexKey{"a": lexkey.Encode("a", 1)}
+	same := map[string]lexkey.LexKey{"a": lexkey.Encode("a", 1)}
+	moved := map[string]lexkey.LexKey{"a": lexkey.Encode("a", 2)}
+
+	// Act & Assert
+	assert.True(t, sameOffsets(before, same))
+	assert.False(t, sameOffsets(before, moved))
+}
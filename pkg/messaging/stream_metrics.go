@@ -0,0 +1,405 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ConsumerMetrics receives stream consumer measurements. Implementations must
+// be safe for concurrent use.
+type ConsumerMetrics interface {
+	// EntryHandled records an entry of space handled in d, across all
+	// attempts of the failure policy. err is the last attempt's error when
+	// every attempt failed.
+	EntryHandled(ctx context.Context, space, discriminator string, d time.Duration, err error)
+	// OffsetCommitted records that space advanced to offset after n entries.
+	OffsetCommitted(space string, offset lexkey.LexKey, n int)
+	// LagChanged records how many entries written to space are past its
+	// committed offset.
+	LagChanged(space string, lag uint64)
+	// OffsetsFlushed records a flush of committed offsets.
+	OffsetsFlushed(ctx context.Context, err error)
+}
+
+// WithMetrics reports consumer measurements to m.
+func WithMetrics(m ConsumerMetrics) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.metrics = m
+		p.lag = nil
+		if m != nil {
+			p.lag = newLagTracker()
+		}
+	}
+}
+
+// commitLag reports the lag of the space of entries once they are committed.
+func (p *StreamProcessorBase) commitLag(entries ...*streamkit.Entry) {
+	if p.lag == nil || len(entries) == 0 {
+		return
+	}
+	p.metrics.LagChanged(entries[0].Space, p.lag.committed(entries...))
+}
+
+// entryDiscriminator returns the entry's discriminator for metrics, or
+// "unknown" when the payload cannot be read.
+func entryDiscriminator(entry *streamkit.Entry) string {
+	discriminator, err := peekDiscriminator(entry.Payload)
+	if err != nil || discriminator == "" {
+		return "unknown"
+	}
+	return discriminator
+}
+
+// lagTracker follows the head and committed sequence of each segment to
+// count the entries of each space past its committed offset. A segment is
+// tracked from the first write the consumer is notified of; entries written
+// before that are assumed consumed.
+type lagTracker struct {
+	mu       sync.Mutex
+	segments map[segmentRef]*segmentLag
+	spaces   map[string]uint64
+}
+
+type segmentLag struct {
+	head      uint64
+	committed uint64
+}
+
+func (s *segmentLag) lag() uint64 {
+	if s.head <= s.committed {
+		return 0
+	}
+	return s.head - s.committed
+}
+
+func newLagTracker() *lagTracker {
+	return &lagTracker{
+		segments: make(map[segmentRef]*segmentLag),
+		spaces:   make(map[string]uint64),
+	}
+}
+
+// update applies fn to the segment and returns the new lag of its space.
+// The caller must hold t.mu.
+func (t *lagTracker) update(ref segmentRef, fn func(*segmentLag)) uint64 {
+	seg, ok := t.segments[ref]
+	if !ok {
+		seg = &segmentLag{}
+		t.segments[ref] = seg
+	}
+	before := seg.lag()
+	fn(seg)
+	t.spaces[ref.space] = t.spaces[ref.space] - before + seg.lag()
+	return t.spaces[ref.space]
+}
+
+// written records that sequences first through last were written to a
+// segment and returns the lag of its space.
+func (t *lagTracker) written(space, segment string, first, last uint64) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.update(segmentRef{space, segment}, func(s *segmentLag) {
+		if s.head == 0 && s.committed == 0 && first > 0 {
+			s.committed = first - 1
+		}
+		s.head = max(s.head, last)
+	})
+}
+
+// committed records that entries, all of one space, were committed and
+// returns the lag of the space.
+func (t *lagTracker) committed(entries ...*streamkit.Entry) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lag uint64
+	for _, entry := range entries {
+		lag = t.update(segmentRef{entry.Space, entry.Segment}, func(s *segmentLag) {
+			s.committed = max(s.committed, entry.Sequence)
+		})
+	}
+	return lag
+}
+
+// LatencyBuckets are the upper bounds of the LatencyStats histogram
+// buckets.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyStats aggregates handling durations.
+type LatencyStats struct {
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+	// Buckets counts durations by histogram bucket: Buckets[i] holds those
+	// up to LatencyBuckets[i] and above the previous bound, and the last
+	// element those above every bound.
+	Buckets []uint64
+}
+
+func (s *LatencyStats) record(d time.Duration) {
+	if s.Buckets == nil {
+		s.Buckets = make([]uint64, len(LatencyBuckets)+1)
+	}
+	s.Count++
+	s.Total += d
+	s.Max = max(s.Max, d)
+	i, _ := slices.BinarySearch(LatencyBuckets, d)
+	s.Buckets[i]++
+}
+
+// Mean returns the average duration, or zero if none was recorded.
+func (s LatencyStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// SpaceMetrics is a snapshot of the measurements of one space.
+type SpaceMetrics struct {
+	Processed  uint64
+	Errors     map[string]uint64
+	Latency    LatencyStats
+	LastOffset lexkey.LexKey
+	LastEvent  time.Time
+	// Lag is the number of entries written past the committed offset.
+	Lag uint64
+}
+
+// SinceLastEvent returns the time since the last handled entry, or zero if
+// none was handled.
+func (m SpaceMetrics) SinceLastEvent() time.Duration {
+	if m.LastEvent.IsZero() {
+		return 0
+	}
+	return time.Since(m.LastEvent)
+}
+
+// MemoryConsumerMetrics keeps measurements in memory, with handling
+// durations bucketed as in LatencyBuckets. It is intended for tests and
+// debugging endpoints.
+type MemoryConsumerMetrics struct {
+	mu          sync.Mutex
+	spaces      map[string]*SpaceMetrics
+	flushes     uint64
+	flushErrors uint64
+}
+
+// NewMemoryConsumerMetrics returns an empty MemoryConsumerMetrics.
+func NewMemoryConsumerMetrics() *MemoryConsumerMetrics {
+	return &MemoryConsumerMetrics{spaces: make(map[string]*SpaceMetrics)}
+}
+
+// space returns the metrics of space. The caller must hold m.mu.
+func (m *MemoryConsumerMetrics) space(space string) *SpaceMetrics {
+	sm, ok := m.spaces[space]
+	if !ok {
+		sm = &SpaceMetrics{Errors: make(map[string]uint64)}
+		m.spaces[space] = sm
+	}
+	return sm
+}
+
+func (m *MemoryConsumerMetrics) EntryHandled(ctx context.Context, space, discriminator string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sm := m.space(space)
+	sm.Latency.record(d)
+	sm.LastEvent = time.Now()
+	if err != nil {
+		sm.Errors[discriminator]++
+		return
+	}
+	sm.Processed++
+}
+
+func (m *MemoryConsumerMetrics) OffsetCommitted(space string, offset lexkey.LexKey, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.space(space).LastOffset = offset
+}
+
+func (m *MemoryConsumerMetrics) LagChanged(space string, lag uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.space(space).Lag = lag
+}
+
+func (m *MemoryConsumerMetrics) OffsetsFlushed(ctx context.Context, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushes++
+	if err != nil {
+		m.flushErrors++
+	}
+}
+
+// Space returns a snapshot of the metrics of space.
+func (m *MemoryConsumerMetrics) Space(space string) SpaceMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sm, ok := m.spaces[space]
+	if !ok {
+		return SpaceMetrics{Errors: map[string]uint64{}}
+	}
+	snapshot := *sm
+	snapshot.Errors = maps.Clone(sm.Errors)
+	snapshot.Latency.Buckets = slices.Clone(sm.Latency.Buckets)
+	return snapshot
+}
+
+// Flushes returns the number of flushes and how many of them failed.
+func (m *MemoryConsumerMetrics) Flushes() (total, failed uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flushes, m.flushErrors
+}
+
+// OtelConsumerMetrics exports consumer measurements through OpenTelemetry:
+// counters of handled entries and flushes, a handler duration histogram, and
+// gauges of each space's lag and the seconds since it last handled an entry.
+// Lexkey offsets have no numeric form, so the last offset is not exported;
+// the lag gauge tracks progress instead.
+type OtelConsumerMetrics struct {
+	attrs    []attribute.KeyValue
+	entries  metric.Int64Counter
+	errors   metric.Int64Counter
+	duration metric.Float64Histogram
+	flushes  metric.Int64Counter
+
+	mu        sync.Mutex
+	lastEvent map[string]time.Time
+	lag       map[string]uint64
+}
+
+// NewOtelConsumerMetrics creates the instruments on meter, adding attrs to
+// every measurement. A nil meter uses the global OpenTelemetry meter
+// provider.
+func NewOtelConsumerMetrics(meter metric.Meter, attrs ...attribute.KeyValue) *OtelConsumerMetrics {
+	if meter == nil {
+		meter = otel.Meter(instrumentationName)
+	}
+	m := &OtelConsumerMetrics{
+		attrs:     attrs,
+		lastEvent: make(map[string]time.Time),
+		lag:       make(map[string]uint64),
+	}
+
+	var errs []error
+	var err error
+	m.entries, err = meter.Int64Counter("mesh.stream.consumer.entries",
+		metric.WithDescription("Number of stream entries handled."))
+	errs = append(errs, err)
+	m.errors, err = meter.Int64Counter("mesh.stream.consumer.errors",
+		metric.WithDescription("Number of stream entries whose handling failed."))
+	errs = append(errs, err)
+	m.duration, err = meter.Float64Histogram("mesh.stream.consumer.handler.duration",
+		metric.WithDescription("Duration of stream entry handling across attempts."),
+		metric.WithUnit("s"))
+	errs = append(errs, err)
+	m.flushes, err = meter.Int64Counter("mesh.stream.consumer.flushes",
+		metric.WithDescription("Number of consumer offset flushes."))
+	errs = append(errs, err)
+	_, err = meter.Float64ObservableGauge("mesh.stream.consumer.last_event_age",
+		metric.WithDescription("Seconds since the space last handled an entry."),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(m.observeAge))
+	errs = append(errs, err)
+	_, err = meter.Int64ObservableGauge("mesh.stream.consumer.lag",
+		metric.WithDescription("Number of entries written to the space past its committed offset."),
+		metric.WithInt64Callback(m.observeLag))
+	errs = append(errs, err)
+	if err := errors.Join(errs...); err != nil {
+		slog.Warn("failed to create stream consumer metrics instruments", "error", err)
+	}
+	return m
+}
+
+func (m *OtelConsumerMetrics) with(attrs ...attribute.KeyValue) metric.MeasurementOption {
+	return metric.WithAttributes(append(append([]attribute.KeyValue(nil), m.attrs...), attrs...)...)
+}
+
+func (m *OtelConsumerMetrics) EntryHandled(ctx context.Context, space, discriminator string, d time.Duration, err error) {
+	m.mu.Lock()
+	m.lastEvent[space] = time.Now()
+	m.mu.Unlock()
+
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	opt := m.with(
+		attribute.String("messaging.space", space),
+		attribute.String("messaging.discriminator", discriminator),
+		attribute.String("outcome", outcome))
+	if m.entries != nil {
+		m.entries.Add(ctx, 1, opt)
+	}
+	if m.duration != nil {
+		m.duration.Record(ctx, d.Seconds(), opt)
+	}
+	if err != nil && m.errors != nil {
+		m.errors.Add(ctx, 1, m.with(
+			attribute.String("messaging.space", space),
+			attribute.String("messaging.discriminator", discriminator)))
+	}
+}
+
+func (m *OtelConsumerMetrics) OffsetCommitted(space string, offset lexkey.LexKey, n int) {}
+
+func (m *OtelConsumerMetrics) LagChanged(space string, lag uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lag[space] = lag
+}
+
+func (m *OtelConsumerMetrics) OffsetsFlushed(ctx context.Context, err error) {
+	if m.flushes == nil {
+		return
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	m.flushes.Add(ctx, 1, m.with(attribute.String("outcome", outcome)))
+}
+
+func (m *OtelConsumerMetrics) observeAge(ctx context.Context, o metric.Float64Observer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for space, at := range m.lastEvent {
+		o.Observe(time.Since(at).Seconds(), m.with(attribute.String("messaging.space", space)))
+	}
+	return nil
+}
+
+func (m *OtelConsumerMetrics) observeLag(ctx context.Context, o metric.Int64Observer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for space, lag := range m.lag {
+		o.Observe(int64(lag), m.with(attribute.String("messaging.space", space)))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestShouldRecordRetriedEntryOnce(t *testing.T) {
+	// Arrange
+	p, _ := newFailingProcessor(t, 1)
+	metrics := NewMemoryConsumerMetrics()
+	WithMetrics(metrics)(p)
+	WithFailurePolicy(FailurePolicy{MaxAttempts: 2})(p)
+
+	// Act
+	err := p.processEntry(context.Background(), newTestEntry(t, 1, "a"))
+
+	// Assert
+	require.NoError(t, err)
+	space := metrics.Space("test-space")
+	assert.Equal(t, uint64(1), space.Processed)
+	assert.Empty(t, space.Errors)
+	assert.Equal(t, uint64(1), space.Latency.Count)
+	assert.False(t, space.LastEvent.IsZero())
+}
+
+func TestShouldRecordEntryThatFailedEveryAttemptAsError(t *testing.T) {
+	// Arrange
+	p, _ := newFailingProcessor(t, 2)
+	metrics := NewMemoryConsumerMetrics()
+	WithMetrics(metrics)(p)
+	WithFailurePolicy(FailurePolicy{MaxAttempts: 2})(p)
+
+	// Act
+	err := p.processEntry(context.Background(), newTestEntry(t, 1, "a"))
+
+	// Assert
+	require.Error(t, err)
+	space := metrics.Space("test-space")
+	assert.Equal(t, uint64(0), space.Processed)
+	assert.Equal(t, map[string]uint64{"mesh://test/event": 1}, space.Errors)
+	assert.Equal(t, uint64(1), space.Latency.Count)
+}
+
+func TestShouldAggregateLatencies(t *testing.T) {
+	// Arrange
+	metrics := NewMemoryConsumerMetrics()
+
+	// Act
+	for _, d := range []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 20 * time.Millisecond} {
+		metrics.EntryHandled(context.Background(), "space", "event", d, nil)
+	}
+
+	// Assert
+	latency := metrics.Space("space").Latency
+	assert.Equal(t, uint64(3), latency.Count)
+	assert.Equal(t, 30*time.Millisecond, latency.Max)
+	assert.Equal(t, 20*time.Millisecond, latency.Mean())
+}
+
+func TestShouldBucketLatencies(t *testing.T) {
+	// Arrange
+	metrics := NewMemoryConsumerMetrics()
+
+	// Act
+	for _, d := range []time.Duration{0, time.Millisecond, 2 * time.Millisecond, 30 * time.Millisecond, time.Minute} {
+		metrics.EntryHandled(context.Background(), "space", "event", d, nil)
+	}
+
+	// Assert
+	buckets := metrics.Space("space").Latency.Buckets
+	require.Len(t, buckets, len(LatencyBuckets)+1)
+	assert.Equal(t, uint64(2), buckets[0])
+	assert.Equal(t, uint64(1), buckets[1])
+	assert.Equal(t, uint64(1), buckets[4])
+	assert.Equal(t, uint64(1), buckets[len(LatencyBuckets)])
+}
+
+func TestShouldReportLagBetweenHeadAndCommittedOffset(t *testing.T) {
+	// Arrange
+	metrics := NewMemoryConsumerMetrics()
+	p := newFlushingProcessor(&flushRecorder{}, WithMetrics(metrics))
+	p.handleSegmentStatus(&streamkit.SegmentStatus{Space: "test-space", Segment: "segment", FirstSequence: 1, LastSequence: 3})
+	p.handleSegmentStatus(&streamkit.SegmentStatus{Space: "test-space", Segment: "other", FirstSequence: 5, LastSequence: 6})
+	require.Equal(t, uint64(5), metrics.Space("test-space").Lag)
+
+	// Act
+	p.commitLag(newTestEntry(t, 2, "a"))
+
+	// Assert
+	assert.Equal(t, uint64(3), metrics.Space("test-space").Lag)
+}
+
+func TestShouldRecordCommittedOffsetsAndFlushes(t *testing.T) {
+	// Arrange
+	recorder := &flushRecorder{}
+	metrics := NewMemoryConsumerMetrics()
+	p := newFlushingProcessor(recorder, WithBatchSize(1), WithMetrics(metrics))
+
+	// Act
+	err := p.commitOffset(context.Background(), "space", lexkey.Encode("space", 3), 1)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, lexkey.Encode("space", 3), metrics.Space("space").LastOffset)
+	total, failed := metrics.Flushes()
+	assert.Equal(t, uint64(1), total)
+	assert.Equal(t, uint64(0), failed)
+}
+
+func TestShouldExportConsumerMetricsThroughOtel(t *testing.T) {
+	// Arrange
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	metrics := NewOtelConsumerMetrics(provider.Meter("test"))
+	p, _ := newFailingProcessor(t, 0)
+	WithMetrics(metrics)(p)
+	p.handleSegmentStatus(&streamkit.SegmentStatus{Space: "test-space", Segment: "segment", FirstSequence: 1, LastSequence: 1})
+
+	// Act
+	require.NoError(t, p.processEntry(context.Background(), newTestEntry(t, 1, "a")))
+	var data metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &data))
+
+	// Assert
+	names := map[string]bool{}
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			names[m.Name] = true
+		}
+	}
+	assert.True(t, names["mesh.stream.consumer.entries"])
+	assert.True(t, names["mesh.stream.consumer.handler.duration"])
+	assert.True(t, names["mesh.stream.consumer.last_event_age"])
+	assert.True(t, names["mesh.stream.consumer.lag"])
+}
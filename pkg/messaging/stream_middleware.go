@@ -0,0 +1,209 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/fgrzl/streamkit/pkg/api"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/meshctx"
+)
+
+// ErrInvalidDiscriminator is wrapped by errors returned when registering a
+// stream handler for an empty or malformed discriminator.
+var ErrInvalidDiscriminator = errors.New("invalid stream discriminator")
+
+// TenantMetadataKey is the entry metadata key StreamTenantMiddleware reads the
+// tenant ID from.
+const TenantMetadataKey = "tenant_id"
+
+// StreamHandlerInfo describes the stream handler a middleware is wrapping.
+type StreamHandlerInfo struct {
+	// Pattern is the discriminator or wildcard pattern the handler was
+	// registered for.
+	Pattern string
+}
+
+// StreamMiddleware decorates a stream handler. Like Middleware it is invoked
+// once per registration with the StreamHandlerInfo of the handler.
+type StreamMiddleware func(info StreamHandlerInfo, next PolymorphicStreamHandler) PolymorphicStreamHandler
+
+// UseStream appends middleware to the stream handler pipeline. Middleware
+// only applies to handlers registered after the call.
+func (p *StreamProcessorBase) UseStream(mws ...StreamMiddleware) {
+	p.handlerMu.Lock()
+	defer p.handlerMu.Unlock()
+	p.streamMiddleware = append(p.streamMiddleware, mws...)
+}
+
+// chainStreamMiddleware applies mws to h so that mws[0] is the outermost layer.
+func chainStreamMiddleware(info StreamHandlerInfo, h PolymorphicStreamHandler, mws []StreamMiddleware) PolymorphicStreamHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](info, h)
+		}
+	}
+	return h
+}
+
+type patternHandler struct {
+	prefix  string
+	handler PolymorphicStreamHandler
+}
+
+// parseDiscriminatorPattern validates a registration discriminator and
+// reports whether it is a prefix wildcard.
+func parseDiscriminatorPattern(pattern string) (prefix string, wildcard bool, err error) {
+	if pattern == "" {
+		return "", false, fmt.Errorf("%w: empty discriminator", ErrInvalidDiscriminator)
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return pattern, false, nil
+	}
+	if star != len(pattern)-1 {
+		return "", false, fmt.Errorf("%w: %q may only end in a wildcard", ErrInvalidDiscriminator, pattern)
+	}
+	return pattern[:star], true, nil
+}
+
+// handlersFor returns the exact handlers for discriminator followed by every
+// matching wildcard handler, each group in registration order.
+func (p *StreamProcessorBase) handlersFor(discriminator string) []PolymorphicStreamHandler {
+	p.handlerMu.RLock()
+	defer p.handlerMu.RUnlock()
+	handlers := append([]PolymorphicStreamHandler(nil), p.streamHandlers[discriminator]...)
+	for _, ph := range p.patternHandlers {
+		if strings.HasPrefix(discriminator, ph.prefix) {
+			handlers = append(handlers, ph.handler)
+		}
+	}
+	return handlers
+}
+
+type streamEntryKey struct{}
+
+func withStreamEntry(ctx context.Context, entry *streamkit.Entry) context.Context {
+	return context.WithValue(ctx, streamEntryKey{}, entry)
+}
+
+// StreamEntryFromContext returns the entry being handled, for middleware and
+// handlers that need its space, offset or metadata.
+func StreamEntryFromContext(ctx context.Context) (*streamkit.Entry, bool) {
+	entry, ok := ctx.Value(streamEntryKey{}).(*streamkit.Entry)
+	return entry, ok && entry != nil
+}
+
+// entryAttrs returns log attributes identifying the entry in ctx.
+func entryAttrs(ctx context.Context) []any {
+	entry, ok := StreamEntryFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return []any{"space", entry.Space, "segment", entry.Segment, "sequence", entry.Sequence}
+}
+
+// StreamLoggingMiddleware logs every stream handler invocation with its
+// pattern, discriminator, entry position and duration. Failures are logged at
+// Warn, successes at Debug. A nil logger uses slog.Default().
+func StreamLoggingMiddleware(logger *slog.Logger) StreamMiddleware {
+	return func(info StreamHandlerInfo, next PolymorphicStreamHandler) PolymorphicStreamHandler {
+		return func(ctx context.Context, content api.Consumable) error {
+			log := logger
+			if log == nil {
+				log = slog.Default()
+			}
+			start := time.Now()
+			err := next(ctx, content)
+			attrs := append([]any{
+				"pattern", info.Pattern,
+				"discriminator", content.GetDiscriminator(),
+				"duration_ms", time.Since(start).Milliseconds(),
+			}, entryAttrs(ctx)...)
+			if err != nil {
+				log.WarnContext(ctx, "stream handler failed", append(attrs, "error", err)...)
+				return err
+			}
+			log.DebugContext(ctx, "stream handler completed", attrs...)
+			return nil
+		}
+	}
+}
+
+// StreamRetryMiddleware retries a failing handler up to attempts times in
+// total, waiting backoff(n) before retry n (no wait when nil). Unlike
+// WithFailurePolicy, which retries every handler of an entry, it retries only
+// the wrapped handler.
+func StreamRetryMiddleware(attempts int, backoff func(attempt int) time.Duration) StreamMiddleware {
+	return func(info StreamHandlerInfo, next PolymorphicStreamHandler) PolymorphicStreamHandler {
+		if attempts <= 1 {
+			return next
+		}
+		return func(ctx context.Context, content api.Consumable) error {
+			var err error
+			for attempt := 1; attempt <= attempts; attempt++ {
+				if err = next(ctx, content); err == nil {
+					return nil
+				}
+				if attempt == attempts || ctx.Err() != nil {
+					break
+				}
+				if backoff != nil {
+					if waitErr := sleepContext(ctx, backoff(attempt)); waitErr != nil {
+						break
+					}
+				}
+			}
+			return err
+		}
+	}
+}
+
+// StreamTenantMiddleware stores a tenant ID in the handler context using
+// meshctx.WithTenantID. The tenant is read from the entry's TenantMetadataKey
+// metadata, falling back to tenantID when it is not uuid.Nil. Contexts that
+// already hold a meshctx tenant are left untouched.
+func StreamTenantMiddleware(tenantID uuid.UUID) StreamMiddleware {
+	return func(info StreamHandlerInfo, next PolymorphicStreamHandler) PolymorphicStreamHandler {
+		return func(ctx context.Context, content api.Consumable) error {
+			if _, err := meshctx.TenantIDFromContext(ctx); err != nil {
+				if tenant, ok := entryTenant(ctx, tenantID); ok {
+					ctx = meshctx.WithTenantID(ctx, tenant)
+				}
+			}
+			return next(ctx, content)
+		}
+	}
+}
+
+func entryTenant(ctx context.Context, fallback uuid.UUID) (uuid.UUID, bool) {
+	if entry, ok := StreamEntryFromContext(ctx); ok {
+		if raw, ok := entry.Metadata[TenantMetadataKey]; ok {
+			if tenant, err := uuid.Parse(raw); err == nil {
+				return tenant, true
+			}
+		}
+	}
+	return fallback, fallback != uuid.Nil
+}
+
+// StreamTimingMiddleware calls observe with the duration and outcome of every
+// stream handler invocation.
+func StreamTimingMiddleware(observe func(ctx context.Context, info StreamHandlerInfo, discriminator string, d time.Duration, err error)) StreamMiddleware {
+	return func(info StreamHandlerInfo, next PolymorphicStreamHandler) PolymorphicStreamHandler {
+		if observe == nil {
+			return next
+		}
+		return func(ctx context.Context, content api.Consumable) error {
+			start := time.Now()
+			err := next(ctx, content)
+			observe(ctx, info, content.GetDiscriminator(), time.Since(start), err)
+			return err
+		}
+	}
+}
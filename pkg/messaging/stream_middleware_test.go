@@ -0,0 +1,185 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/streamkit/pkg/api"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/meshctx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldRunEveryHandlerRegisteredForAnEvent(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	var calls []string
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		calls = append(calls, "first:"+e.Value)
+		return nil
+	}))
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		calls = append(calls, "second:"+e.Value)
+		return nil
+	}))
+
+	// Act
+	err := p.handleEntry(context.Background(), newTestEntry(t, 1, "hello"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first:hello", "second:hello"}, calls)
+}
+
+func TestShouldDispatchToWildcardHandler(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	var got string
+	require.NoError(t, RegisterStreamPatternHandler(p, "mesh://test/*", func(ctx context.Context, c api.Consumable) error {
+		got = c.GetDiscriminator()
+		return nil
+	}, "test-space"))
+
+	// Act
+	err := p.handleEntry(context.Background(), newTestEntry(t, 1, "hello"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "mesh://test/event", got)
+	assert.True(t, p.spaces.Contains("test-space"))
+}
+
+func TestShouldTreatUnmatchedWildcardAsUnknown(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	require.NoError(t, p.RegisterStreamHandler("mesh://catalog/*", func(ctx context.Context, c api.Consumable) error {
+		return nil
+	}))
+
+	// Act
+	err := p.handleEntry(context.Background(), newTestEntry(t, 1, "hello"))
+
+	// Assert
+	assert.ErrorContains(t, err, "no handler registered")
+}
+
+func TestShouldRejectInvalidDiscriminators(t *testing.T) {
+	handler := func(ctx context.Context, c api.Consumable) error { return nil }
+	for name, pattern := range map[string]string{
+		"empty":           "",
+		"inner wildcard":  "mesh://*/event",
+		"double wildcard": "mesh://**",
+	} {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			p := NewStreamProcessorBase(nil, uuid.Nil)
+
+			// Act
+			err := p.RegisterStreamHandler(pattern, handler)
+
+			// Assert
+			assert.ErrorIs(t, err, ErrInvalidDiscriminator)
+		})
+	}
+}
+
+func TestShouldReturnErrorForNilStreamHandler(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+
+	// Act
+	err := RegisterStreamHandler[*testEvent](p, nil)
+
+	// Assert
+	assert.Error(t, err)
+	assert.False(t, p.spaces.Contains("test-space"))
+}
+
+func TestShouldApplyStreamMiddlewareInOrder(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	var order []string
+	layer := func(name string) StreamMiddleware {
+		return func(info StreamHandlerInfo, next PolymorphicStreamHandler) PolymorphicStreamHandler {
+			return func(ctx context.Context, c api.Consumable) error {
+				order = append(order, name+":"+info.Pattern)
+				return next(ctx, c)
+			}
+		}
+	}
+	p.UseStream(layer("outer"), layer("inner"))
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		order = append(order, "handler")
+		return nil
+	}))
+
+	// Act
+	err := p.handleEntry(context.Background(), newTestEntry(t, 1, "hello"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"outer:mesh://test/event", "inner:mesh://test/event", "handler"}, order)
+}
+
+func TestShouldRetryOnlyTheWrappedHandler(t *testing.T) {
+	// Arrange
+	attempts := 0
+	h := StreamRetryMiddleware(3, nil)(StreamHandlerInfo{}, func(ctx context.Context, c api.Consumable) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	// Act
+	err := h(context.Background(), &testEvent{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestShouldInjectTenantFromEntryMetadata(t *testing.T) {
+	// Arrange
+	tenantID := uuid.New()
+	entry := newTestEntry(t, 1, "hello")
+	entry.Metadata = map[string]string{TenantMetadataKey: tenantID.String()}
+	var got uuid.UUID
+	h := StreamTenantMiddleware(uuid.New())(StreamHandlerInfo{}, func(ctx context.Context, c api.Consumable) error {
+		var err error
+		got, err = meshctx.TenantIDFromContext(ctx)
+		return err
+	})
+
+	// Act
+	err := h(withStreamEntry(context.Background(), entry), &testEvent{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, tenantID, got)
+}
+
+func TestShouldObserveHandlerTiming(t *testing.T) {
+	// Arrange
+	var observed string
+	var observedErr error
+	failure := errors.New("boom")
+	h := StreamTimingMiddleware(func(ctx context.Context, info StreamHandlerInfo, discriminator string, d time.Duration, err error) {
+		observed = discriminator
+		observedErr = err
+	})(StreamHandlerInfo{}, func(ctx context.Context, c api.Consumable) error {
+		return failure
+	})
+
+	// Act
+	err := h(context.Background(), &testEvent{})
+
+	// Assert
+	assert.ErrorIs(t, err, failure)
+	assert.Equal(t, "mesh://test/event", observed)
+	assert.ErrorIs(t, observedErr, failure)
+}
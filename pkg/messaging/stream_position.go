@@ -0,0 +1,195 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"github.com/fgrzl/enumerators"
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+)
+
+type startKind int
+
+const (
+	startBeginning startKind = iota
+	startLatest
+	startOffsets
+)
+
+// StartPosition selects where a consumer without a stored offset begins, or
+// where ResetConsumer moves it to. Positions are space offsets; streamkit's
+// Consume has no timestamp addressing, so starting from a point in time is
+// not supported.
+type StartPosition struct {
+	kind    startKind
+	offsets map[string]lexkey.LexKey
+}
+
+// StartFromBeginning replays every space from its first entry.
+func StartFromBeginning() StartPosition {
+	return StartPosition{kind: startBeginning}
+}
+
+// StartFromLatest skips existing entries and handles only new ones. The
+// stream client must implement SegmentClient.
+func StartFromLatest() StartPosition {
+	return StartPosition{kind: startLatest}
+}
+
+// StartFromOffsets resumes each space after the given offset. Spaces missing
+// from offsets start from the beginning.
+func StartFromOffsets(offsets map[string]lexkey.LexKey) StartPosition {
+	return StartPosition{kind: startOffsets, offsets: maps.Clone(offsets)}
+}
+
+// WithStartPosition sets where the consumer begins when no offset was loaded
+// or supplied with WithOffset. The default is StartFromBeginning.
+func WithStartPosition(pos StartPosition) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.startPosition = &pos
+	}
+}
+
+// ResetConsumer stops the consumer, moves it to pos, persists the new
+// offsets through the flush hook and starts it again with the context it was
+// originally started with. A consumer that is not running is moved to pos on
+// its next start.
+func (p *StreamProcessorBase) ResetConsumer(ctx context.Context, pos StartPosition) error {
+	p.mu.Lock()
+	parent := p.startCtx
+	p.mu.Unlock()
+
+	if err := p.Stop(ctx); err != nil {
+		return fmt.Errorf("failed to stop consumer for reset: %w", err)
+	}
+
+	p.offsetMu.Lock()
+	p.resetTo = &pos
+	p.pending = 0
+	p.offsetMu.Unlock()
+
+	if parent == nil || parent.Err() != nil {
+		return nil
+	}
+	p.log().InfoContext(ctx, "restarting stream consumer after reset")
+	return p.StartConsumer(parent)
+}
+
+// initialOffset decides the offsets a run starts from: a pending reset wins,
+// then a loaded or supplied offset, then the start position.
+func (p *StreamProcessorBase) initialOffset(ctx context.Context, spaces []string) error {
+	p.offsetMu.Lock()
+	reset := p.resetTo
+	p.offsetMu.Unlock()
+
+	if reset != nil {
+		off, err := p.resolveStartPosition(ctx, *reset, spaces)
+		if err != nil {
+			return fmt.Errorf("failed to resolve reset position: %w", err)
+		}
+		if p.flushOffset != nil {
+			if err := p.flushOffset(ctx, off.clone()); err != nil {
+				return fmt.Errorf("failed to store reset offset: %w", err)
+			}
+		}
+		p.offsetMu.Lock()
+		p.offset = off
+		p.resetTo = nil
+		p.offsetMu.Unlock()
+		return nil
+	}
+
+	if p.loadOffset != nil {
+		off, err := p.loadOffset(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load consumer offset: %w", err)
+		}
+		if off != nil {
+			p.offsetMu.Lock()
+			p.offset = off
+			p.offsetMu.Unlock()
+		}
+	}
+
+	p.offsetMu.Lock()
+	missing := p.offset == nil
+	p.offsetMu.Unlock()
+	if !missing {
+		return nil
+	}
+
+	pos := StartFromBeginning()
+	if p.startPosition != nil {
+		pos = *p.startPosition
+	}
+	off, err := p.resolveStartPosition(ctx, pos, spaces)
+	if err != nil {
+		return fmt.Errorf("failed to resolve start position: %w", err)
+	}
+	p.offsetMu.Lock()
+	p.offset = off
+	p.offsetMu.Unlock()
+	return nil
+}
+
+// resolveStartPosition converts pos into offsets for spaces.
+func (p *StreamProcessorBase) resolveStartPosition(ctx context.Context, pos StartPosition, spaces []string) (*ConsumerOffset, error) {
+	off := &ConsumerOffset{Offsets: make(map[string]lexkey.LexKey, len(spaces))}
+	for _, space := range spaces {
+		switch pos.kind {
+		case startLatest:
+			latest, err := p.latestOffset(ctx, space)
+			if err != nil {
+				return nil, err
+			}
+			off.Offsets[space] = latest
+		case startOffsets:
+			if key, ok := pos.offsets[space]; ok {
+				off.Offsets[space] = key
+			} else {
+				off.Offsets[space] = lexkey.Empty
+			}
+		default:
+			off.Offsets[space] = lexkey.Empty
+		}
+	}
+	return off, nil
+}
+
+// SegmentClient is implemented by stream clients that can list the segments
+// of a space and peek at the last entry of each, as streamkit.Client and
+// memstream.Client do. StartFromLatest requires it.
+type SegmentClient interface {
+	GetSegments(ctx context.Context, storeID uuid.UUID, space string) enumerators.Enumerator[string]
+	Peek(ctx context.Context, storeID uuid.UUID, space, segment string) (*streamkit.Entry, error)
+}
+
+// latestOffset returns the offset of the last entry of space, or lexkey.Empty
+// if it has none. It peeks at the last entry of every segment rather than
+// reading the space.
+func (p *StreamProcessorBase) latestOffset(ctx context.Context, space string) (lexkey.LexKey, error) {
+	segments, ok := p.stream.(SegmentClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to find latest offset of space %q: stream client %T cannot list segments", space, p.stream)
+	}
+	latest := lexkey.Empty
+	err := enumerators.ForEach(segments.GetSegments(ctx, p.storeID, space), func(segment string) error {
+		last, err := segments.Peek(ctx, p.storeID, space, segment)
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			if offset := last.GetSpaceOffset(); lexkey.Compare(offset, latest) > 0 {
+				latest = offset
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find latest offset of space %q: %w", space, err)
+	}
+	return latest, nil
+}
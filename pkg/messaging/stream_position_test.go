@@ -0,0 +1,86 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/messaging/memstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldUseStartPositionWhenNoOffsetLoaded(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	p.RegisterOffsetLoader(func(ctx context.Context) (*ConsumerOffset, error) { return nil, nil })
+	key := lexkey.Encode("orders", 5)
+	WithStartPosition(StartFromOffsets(map[string]lexkey.LexKey{"orders": key}))(p)
+
+	// Act
+	err := p.initialOffset(context.Background(), []string{"orders", "invoices"})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, key, p.offset.Offsets["orders"])
+	assert.Equal(t, lexkey.Empty, p.offset.Offsets["invoices"])
+}
+
+func TestShouldPreferLoadedOffsetOverStartPosition(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	stored := lexkey.Encode("orders", 9)
+	p.RegisterOffsetLoader(func(ctx context.Context) (*ConsumerOffset, error) {
+		return &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"orders": stored}}, nil
+	})
+	WithStartPosition(StartFromBeginning())(p)
+
+	// Act
+	err := p.initialOffset(context.Background(), []string{"orders"})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, stored, p.offset.Offsets["orders"])
+}
+
+func TestShouldApplyAndPersistResetOnNextStart(t *testing.T) {
+	// Arrange
+	store := NewMemoryOffsetStore()
+	require.NoError(t, store.Save(context.Background(), "projection", &ConsumerOffset{
+		Offsets: map[string]lexkey.LexKey{"orders": lexkey.Encode("orders", 9)},
+	}))
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	WithOffsetStore(store, "projection")(p)
+
+	// Act
+	require.NoError(t, p.ResetConsumer(context.Background(), StartFromBeginning()))
+	err := p.initialOffset(context.Background(), []string{"orders"})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, lexkey.Empty, p.offset.Offsets["orders"])
+	stored, err := store.Load(context.Background(), "projection")
+	require.NoError(t, err)
+	assert.Equal(t, lexkey.Empty, stored.Offsets["orders"])
+	assert.Nil(t, p.resetTo, "reset must only apply once")
+}
+
+func TestShouldStartFromLastEntryAcrossSegments(t *testing.T) {
+	// Arrange
+	stream := memstream.New()
+	storeID := uuid.New()
+	stream.Append(storeID, "orders", "b", &streamkit.Record{Payload: []byte("1")})
+	last := stream.Append(storeID, "orders", "a", &streamkit.Record{Payload: []byte("2")})[0]
+	p := NewStreamProcessorBase(stream, storeID)
+	WithStartPosition(StartFromLatest())(p)
+
+	// Act
+	err := p.initialOffset(context.Background(), []string{"orders", "invoices"})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, last.GetSpaceOffset(), p.offset.Offsets["orders"])
+	assert.Equal(t, lexkey.Empty, p.offset.Offsets["invoices"])
+}
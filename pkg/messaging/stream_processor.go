@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fgrzl/collections"
@@ -15,6 +18,10 @@ import (
 	streamkit "github.com/fgrzl/streamkit/pkg/client"
 	"github.com/fgrzl/tickle"
 	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/lifecycle"
+	"github.com/hydn-co/mesh-sdk/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PolymorphicStreamHandler is the signature for registered stream handlers.
@@ -22,14 +29,20 @@ type PolymorphicStreamHandler = func(context.Context, api.Consumable) error
 
 // StreamProcessor defines the interface required to register handlers.
 type StreamProcessor interface {
-	RegisterStreamHandler(discriminator string, handler PolymorphicStreamHandler)
+	RegisterStreamHandler(discriminator string, handler PolymorphicStreamHandler) error
 	RegisterSpaces(spaces ...string)
 	RegisterOffsetLoader(func(ctx context.Context) (*ConsumerOffset, error))
 	RegisterFlushOffset(func(ctx context.Context, off *ConsumerOffset) error)
 }
 
-// RegisterStreamHandler binds a strongly typed handler for a specific stream event.
+// RegisterStreamHandler binds a strongly typed handler for a specific stream
+// event. Several handlers may be registered for the same event type; they run
+// in registration order and the first failure fails the entry, so handlers
+// retried by a FailurePolicy must be idempotent.
 func RegisterStreamHandler[T api.Consumable](p StreamProcessor, handler func(context.Context, T) error) error {
+	if handler == nil {
+		return errors.New("stream handler must not be nil")
+	}
 	var zero T
 	discriminator := zero.GetDiscriminator()
 	spaces := zero.GetSpaces()
@@ -42,9 +55,22 @@ func RegisterStreamHandler[T api.Consumable](p StreamProcessor, handler func(con
 		return handler(ctx, content)
 	}
 
+	if err := p.RegisterStreamHandler(discriminator, wrapper); err != nil {
+		return err
+	}
 	p.RegisterSpaces(spaces...)
-	p.RegisterStreamHandler(discriminator, wrapper)
+	return nil
+}
 
+// RegisterStreamPatternHandler binds handler to every discriminator matching
+// pattern, which is either an exact discriminator or a prefix ending in "*"
+// such as "mesh://catalog/*", and consumes the given spaces. Matched events
+// must still be registered with polymorphic so they can be decoded.
+func RegisterStreamPatternHandler(p StreamProcessor, pattern string, handler PolymorphicStreamHandler, spaces ...string) error {
+	if err := p.RegisterStreamHandler(pattern, handler); err != nil {
+		return err
+	}
+	p.RegisterSpaces(spaces...)
 	return nil
 }
 
@@ -77,44 +103,100 @@ func WithBatchSize(n int) ConsumerOptions {
 	}
 }
 
+// StreamClient is the part of streamkit.Client a StreamProcessorBase reads
+// from. Every streamkit.Client satisfies it, as does memstream.Client for
+// tests and local development.
+type StreamClient interface {
+	Consume(ctx context.Context, storeID uuid.UUID, args *streamkit.Consume) enumerators.Enumerator[*streamkit.Entry]
+	SubscribeToSpace(ctx context.Context, storeID uuid.UUID, space string, handler func(*streamkit.SegmentStatus)) (api.Subscription, error)
+}
+
 // StreamProcessorBase is a reusable stream processor implementation.
 type StreamProcessorBase struct {
-	stream         streamkit.Client
-	tickler        *tickle.Tickler
-	storeID        uuid.UUID
-	spaces         *collections.HashSet[string]
-	subs           []api.Subscription
-	streamHandlers map[string]PolymorphicStreamHandler
-	loadOffset     func(ctx context.Context) (*ConsumerOffset, error)
-	flushOffset    func(context.Context, *ConsumerOffset) error
-	offset         *ConsumerOffset
-	batchSize      int
+	stream  StreamClient
+	tickler *tickle.Tickler
+	storeID uuid.UUID
+	spaces  *collections.HashSet[string]
+	subs    []api.Subscription
+	// handlerMu guards streamHandlers, patternHandlers and streamMiddleware
+	handlerMu           sync.RWMutex
+	streamHandlers      map[string][]PolymorphicStreamHandler
+	patternHandlers     []patternHandler
+	streamMiddleware    []StreamMiddleware
+	loadOffset          func(ctx context.Context) (*ConsumerOffset, error)
+	flushOffset         func(context.Context, *ConsumerOffset) error
+	offset              *ConsumerOffset
+	batchSize           int
+	failurePolicy       FailurePolicy
+	logger              *slog.Logger
+	onError             ConsumerErrorHandler
+	errStats            errorStats
+	restartPolicy       lifecycle.Policy
+	startPosition       *StartPosition
+	spaceWorkers        bool
+	workers             int
+	partitionKey        PartitionKeyFunc
+	unknownPolicy       UnknownDiscriminatorPolicy
+	unknownHandler      UnknownStreamHandler
+	skipped             skipCounter
+	group               *groupState
+	dedupStore          DedupStore
+	dedupKey            DedupKeyFunc
+	duplicates          atomic.Uint64
+	throttle            *throttle
+	metrics             ConsumerMetrics
+	lag                 *lagTracker
+	idle                IdleStrategy
+	missedNotifications atomic.Uint64
+	// offsetMu guards offset, pending and lastFlush; flushMu serializes flushes
+	offsetMu          sync.Mutex
+	flushMu           sync.Mutex
+	pending           int
+	resetTo           *StartPosition
+	lastFlush         time.Time
+	flushInterval     time.Duration
+	finalFlushTimeout time.Duration
 	// lifecycle controls for the consumer goroutine
 	mu        sync.Mutex
 	runCancel context.CancelFunc
 	runDone   chan struct{}
 	running   bool
+	status    ConsumerStatus
+	startCtx  context.Context
 }
 
 // NewStreamProcessorBase creates a new base processor with sensible defaults.
-func NewStreamProcessorBase(stream streamkit.Client, storeID uuid.UUID) *StreamProcessorBase {
+func NewStreamProcessorBase(stream StreamClient, storeID uuid.UUID) *StreamProcessorBase {
 	return &StreamProcessorBase{
 		stream:         stream,
 		tickler:        tickle.NewTickler(),
 		storeID:        storeID,
 		spaces:         collections.NewHashSet[string](),
-		streamHandlers: make(map[string]PolymorphicStreamHandler),
+		streamHandlers: make(map[string][]PolymorphicStreamHandler),
 	}
 }
 
-// RegisterStreamHandler registers a handler for a specific event discriminator.
-func (p *StreamProcessorBase) RegisterStreamHandler(discriminator string, handler PolymorphicStreamHandler) {
-
-	if _, exists := p.streamHandlers[discriminator]; exists {
-		panic(fmt.Sprintf("handler already registered for discriminator %q", discriminator))
+// RegisterStreamHandler registers a handler for an event discriminator, or
+// for every discriminator sharing a prefix when discriminator ends in "*".
+// Handlers are wrapped with the stream middleware added by UseStream so far.
+func (p *StreamProcessorBase) RegisterStreamHandler(discriminator string, handler PolymorphicStreamHandler) error {
+	prefix, wildcard, err := parseDiscriminatorPattern(discriminator)
+	if err != nil {
+		return err
+	}
+	if handler == nil {
+		return fmt.Errorf("stream handler for %q must not be nil", discriminator)
 	}
 
-	p.streamHandlers[discriminator] = handler
+	p.handlerMu.Lock()
+	defer p.handlerMu.Unlock()
+	handler = chainStreamMiddleware(StreamHandlerInfo{Pattern: discriminator}, handler, p.streamMiddleware)
+	if wildcard {
+		p.patternHandlers = append(p.patternHandlers, patternHandler{prefix: prefix, handler: handler})
+	} else {
+		p.streamHandlers[discriminator] = append(p.streamHandlers[discriminator], handler)
+	}
+	return nil
 }
 
 func (p *StreamProcessorBase) RegisterSpaces(spaces ...string) {
@@ -155,8 +237,14 @@ func (p *StreamProcessorBase) StartConsumer(ctx context.Context, opts ...Consume
 	p.runCancel = cancel
 	p.running = true
 	p.runDone = make(chan struct{})
+	p.startCtx = ctx
+	p.status = ConsumerStatus{State: p.startState(), StartedAt: time.Now().UTC()}
 	p.mu.Unlock()
 
+	p.offsetMu.Lock()
+	p.lastFlush = time.Now()
+	p.offsetMu.Unlock()
+
 	go func() {
 		defer func() {
 			// signal completion
@@ -169,10 +257,8 @@ func (p *StreamProcessorBase) StartConsumer(ctx context.Context, opts ...Consume
 			p.runDone = nil
 			p.mu.Unlock()
 		}()
-		if err := p.runConsumer(childCtx); err != nil {
-			// log to stdout for now; projects can swap this for structured logging
-			fmt.Printf("stream processor run error: %v\n", err)
-		}
+		p.superviseConsumer(childCtx)
+		p.finalFlush(childCtx)
 	}()
 	return nil
 }
@@ -211,29 +297,23 @@ func (p *StreamProcessorBase) Stop(ctx context.Context) error {
 
 // runConsumer contains the blocking consumer loop previously in StartConsumer.
 func (p *StreamProcessorBase) runConsumer(ctx context.Context) error {
-	// If a loader was registered, try to load previously persisted offsets.
-	if p.loadOffset != nil {
-		off, err := p.loadOffset(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to load consumer offset: %w", err)
-		}
-		if off != nil {
-			p.offset = off
-		}
+	spaces := p.spaces.ToSlice()
+	if err := p.initialOffset(ctx, spaces); err != nil {
+		return err
 	}
 
-	if p.offset == nil {
-		p.offset = &ConsumerOffset{Offsets: make(map[string]lexkey.LexKey)}
-	} else if p.offset.Offsets == nil {
+	p.offsetMu.Lock()
+	if p.offset.Offsets == nil {
 		p.offset.Offsets = make(map[string]lexkey.LexKey)
 	}
+	p.offsetMu.Unlock()
 
 	// Default to flushing every event if batchSize not specified
 	if p.batchSize == 0 {
 		p.batchSize = 1
 	}
 
-	spaces := p.spaces.ToSlice()
+	p.subs = p.subs[:0]
 	for _, space := range spaces {
 		sub, err := p.stream.SubscribeToSpace(ctx, p.storeID, space, p.handleSegmentStatus)
 		if err != nil {
@@ -241,67 +321,133 @@ func (p *StreamProcessorBase) runConsumer(ctx context.Context) error {
 		}
 		p.subs = append(p.subs, sub)
 
+		p.offsetMu.Lock()
 		if _, ok := p.offset.Offsets[space]; !ok {
 			p.offset.Offsets[space] = lexkey.Empty
 		}
+		p.offsetMu.Unlock()
 	}
 
-	sub := p.tickler.Subscribe(ctx, spaces...)
-	defer sub.Dispose()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-
-		default:
-			var counter int
-			args := &streamkit.Consume{Offsets: p.offset.Offsets}
-			enumerator := p.stream.Consume(ctx, p.storeID, args)
-
-			err := enumerators.ForEach(enumerator, func(entry *streamkit.Entry) error {
-				if err := p.handleEntry(ctx, entry); err != nil {
-					return err
-				}
-
-				// update offset for the space so next Consume resumes after this entry
-				if entry != nil && entry.Space != "" {
-					p.offset.Offsets[entry.Space] = entry.GetSpaceOffset()
-				}
-
-				counter++
-				if p.flushOffset != nil && counter >= p.batchSize {
-					if err := p.flushOffset(ctx, p.offset); err != nil {
-						return err
-					}
-					counter = 0
-				}
-				return nil
-			})
-			if err != nil {
-				fmt.Printf("error handling entries: %v\n", err)
-			}
+	switch {
+	case p.group != nil && p.group.PerSpace:
+		return p.runGroupSpaces(ctx, spaces)
+	case p.spaceWorkers:
+		return p.runSpaceWorkers(ctx, spaces)
+	case p.workers > 1:
+		return p.consumeLoop(ctx, spaces, p.consumePartitioned)
+	default:
+		return p.consumeLoop(ctx, spaces, p.consumeSequential)
+	}
+}
 
-			if p.flushOffset != nil && counter > 0 {
-				if err := p.flushOffset(ctx, p.offset); err != nil {
-					return err
-				}
-			}
+// consumeSequential handles entries one at a time in enumeration order.
+func (p *StreamProcessorBase) consumeSequential(ctx context.Context, enumerator enumerators.Enumerator[*streamkit.Entry]) error {
+	err := enumerators.ForEach(enumerator, func(entry *streamkit.Entry) error {
+		if err := p.processEntry(ctx, entry); err != nil {
+			return err
+		}
 
-			sub.WaitTimeout(5 * time.Minute)
+		// update offset for the space so next Consume resumes after this entry
+		if entry != nil && entry.Space != "" {
+			err := p.commitOffset(ctx, entry.Space, entry.GetSpaceOffset(), 1)
+			p.commitLag(entry)
+			return err
 		}
+		return nil
+	})
+	p.reportPassError(ctx, err)
+
+	return p.flushPending(ctx)
+}
+
+// offsetsFor returns a copy of the committed offsets for spaces.
+func (p *StreamProcessorBase) offsetsFor(spaces []string) map[string]lexkey.LexKey {
+	p.offsetMu.Lock()
+	defer p.offsetMu.Unlock()
+	offsets := make(map[string]lexkey.LexKey, len(spaces))
+	for _, space := range spaces {
+		offsets[space] = p.offset.Offsets[space]
+	}
+	return offsets
+}
+
+// commitOffset records offset for space after n more entries were handled and
+// flushes once a full batch is pending.
+func (p *StreamProcessorBase) commitOffset(ctx context.Context, space string, offset lexkey.LexKey, n int) error {
+	if p.recordOffset(space, offset, n) {
+		return p.flushPending(ctx)
 	}
+	return nil
+}
+
+// recordOffset stores offset for space and reports whether a full batch is
+// now pending.
+func (p *StreamProcessorBase) recordOffset(space string, offset lexkey.LexKey, n int) bool {
+	p.offsetMu.Lock()
+	p.offset.Offsets[space] = offset
+	p.pending += n
+	due := p.flushOffset != nil && (p.pending >= p.batchSize || p.flushIntervalElapsed())
+	p.offsetMu.Unlock()
+
+	if p.metrics != nil {
+		p.metrics.OffsetCommitted(space, offset, n)
+	}
+	return due
+}
+
+// flushPending passes a snapshot of the committed offsets to the flush hook
+// if any entries were committed since the last flush.
+// A canceled ctx leaves the offsets pending for the final flush on stop
+// rather than handing the hook a context it cannot use.
+func (p *StreamProcessorBase) flushPending(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.offsetMu.Lock()
+	pending := p.pending
+	if p.flushOffset == nil || pending == 0 || p.offset == nil {
+		p.offsetMu.Unlock()
+		return nil
+	}
+	snapshot := p.offset.clone()
+	p.pending = 0
+	p.offsetMu.Unlock()
+
+	err := p.flushOffset(ctx, snapshot)
+	if p.metrics != nil {
+		p.metrics.OffsetsFlushed(ctx, err)
+	}
+	if err != nil {
+		p.offsetMu.Lock()
+		p.pending += pending
+		p.offsetMu.Unlock()
+		return err
+	}
+
+	p.offsetMu.Lock()
+	p.lastFlush = time.Now()
+	p.offsetMu.Unlock()
+	return nil
 }
 
 func (p *StreamProcessorBase) handleEntry(ctx context.Context, entry *streamkit.Entry) error {
-	envelope, err := polymorphic.UnmarshalPolymorphicJSON(entry.Payload)
+	discriminator, err := peekDiscriminator(entry.Payload)
 	if err != nil {
 		return err
 	}
 
-	handler, ok := p.streamHandlers[envelope.Discriminator]
-	if !ok {
-		return fmt.Errorf("no handler registered for discriminator %q", envelope.Discriminator)
+	handlers := p.handlersFor(discriminator)
+	if len(handlers) == 0 {
+		return p.handleUnknown(ctx, discriminator, entry)
+	}
+
+	envelope, err := polymorphic.UnmarshalPolymorphicJSON(entry.Payload)
+	if err != nil {
+		return err
 	}
 
 	content, ok := envelope.Content.(api.Consumable)
@@ -309,10 +455,34 @@ func (p *StreamProcessorBase) handleEntry(ctx context.Context, entry *streamkit.
 		return fmt.Errorf("invalid content type: %T", envelope.Content)
 	}
 
-	return handler(ctx, content)
+	ctx, span := p.startEntrySpan(withStreamEntry(ctx, entry), discriminator, entry)
+	for _, handler := range handlers {
+		if err = handler(ctx, content); err != nil {
+			break
+		}
+	}
+	tracing.End(span, err)
+	return err
+}
+
+// startEntrySpan starts a consumer span for entry, continuing the trace it
+// was produced in.
+func (p *StreamProcessorBase) startEntrySpan(ctx context.Context, discriminator string, entry *streamkit.Entry) (context.Context, trace.Span) {
+	ctx = tracing.ExtractMetadata(ctx, entry.Metadata)
+	return tracing.Tracer().Start(ctx, "consume "+discriminator,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.discriminator", discriminator),
+			attribute.String("messaging.space", entry.Space),
+			attribute.String("messaging.segment", entry.Segment),
+			attribute.Int64("messaging.sequence", int64(entry.Sequence)),
+		))
 }
 
 func (p *StreamProcessorBase) handleSegmentStatus(status *streamkit.SegmentStatus) {
+	if p.lag != nil {
+		p.metrics.LagChanged(status.Space, p.lag.written(status.Space, status.Segment, status.FirstSequence, status.LastSequence))
+	}
 	p.tickler.Tickle(status.Space)
 }
 
@@ -320,3 +490,7 @@ func (p *StreamProcessorBase) handleSegmentStatus(status *streamkit.SegmentStatu
 type ConsumerOffset struct {
 	Offsets map[string]lexkey.LexKey `json:"offsets"`
 }
+
+func (o *ConsumerOffset) clone() *ConsumerOffset {
+	return &ConsumerOffset{Offsets: maps.Clone(o.Offsets)}
+}
@@ -0,0 +1,114 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fgrzl/json/polymorphic"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	polymorphic.RegisterType[testEvent]()
+	polymorphic.RegisterType[testRequest]()
+}
+
+// testEvent implements api.Consumable for stream processor tests.
+type testEvent struct {
+	Value string `json:"value"`
+}
+
+func (*testEvent) GetDiscriminator() string { return "mesh://test/event" }
+
+func (*testEvent) GetSpaces() []string { return []string{"test-space"} }
+
+func newTestEntry(t *testing.T, sequence uint64, value string) *streamkit.Entry {
+	t.Helper()
+	payload, err := polymorphic.MarshalPolymorphicJSON(&testEvent{Value: value})
+	require.NoError(t, err)
+	return &streamkit.Entry{
+		Space:    "test-space",
+		Segment:  "segment",
+		Sequence: sequence,
+		Payload:  payload,
+	}
+}
+
+func TestShouldDispatchEntryToRegisteredHandler(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	var got string
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		got = e.Value
+		return nil
+	}))
+
+	// Act
+	err := p.handleEntry(context.Background(), newTestEntry(t, 1, "hello"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got)
+	assert.True(t, p.spaces.Contains("test-space"))
+}
+
+func TestShouldFailEntryWithoutRegisteredHandler(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+
+	// Act
+	err := p.handleEntry(context.Background(), newTestEntry(t, 1, "hello"))
+
+	// Assert
+	assert.ErrorContains(t, err, "no handler registered")
+}
+
+func TestShouldSkipAndCountUnknownDiscriminator(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	WithUnknownDiscriminatorPolicy(UnknownDiscriminatorSkip)(p)
+	entry := &streamkit.Entry{Space: "test-space", Payload: []byte(`{"$type":"mesh://test/unregistered","content":{}}`)}
+
+	// Act
+	err1 := p.handleEntry(context.Background(), entry)
+	err2 := p.handleEntry(context.Background(), entry)
+
+	// Assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	assert.Equal(t, map[string]uint64{"mesh://test/unregistered": 2}, p.SkippedEvents())
+}
+
+func TestShouldRouteUnknownDiscriminatorToFallbackHandler(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	var got string
+	WithUnknownDiscriminatorHandler(func(ctx context.Context, discriminator string, entry *streamkit.Entry) error {
+		got = discriminator
+		return nil
+	})(p)
+
+	// Act
+	err := p.handleEntry(context.Background(), newTestEntry(t, 1, "hello"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "mesh://test/event", got)
+	assert.Empty(t, p.SkippedEvents())
+}
+
+func TestShouldFailMalformedPayloadRegardlessOfUnknownPolicy(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	WithUnknownDiscriminatorPolicy(UnknownDiscriminatorSkip)(p)
+
+	// Act
+	err := p.handleEntry(context.Background(), &streamkit.Entry{Payload: []byte(`not json`)})
+
+	// Assert
+	assert.Error(t, err)
+	assert.Empty(t, p.SkippedEvents())
+}
@@ -0,0 +1,331 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fgrzl/collections"
+	"github.com/fgrzl/enumerators"
+	"github.com/fgrzl/json/polymorphic"
+	"github.com/fgrzl/streamkit/pkg/api"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/meshctx"
+	"github.com/hydn-co/mesh-sdk/pkg/tracing"
+)
+
+// IdempotencyMetadataKey is the record metadata key holding the idempotency
+// key of a produced event.
+const IdempotencyMetadataKey = "idempotency_key"
+
+// DefaultProducerBatchSize is the number of records sent per Produce call
+// when no batch size is configured.
+const DefaultProducerBatchSize = 100
+
+// IdempotentEvent is implemented by events that supply their own idempotency
+// key. Events without one get a random key per Publish call.
+type IdempotentEvent interface {
+	GetIdempotencyKey() string
+}
+
+// IdempotencyDedupKey uses the entry's idempotency key, falling back to
+// EntryDedupKey for entries produced without one. Use it with
+// WithDeduplication to drop events published more than once.
+func IdempotencyDedupKey(entry *streamkit.Entry) string {
+	if key := entry.Metadata[IdempotencyMetadataKey]; key != "" {
+		return key
+	}
+	return EntryDedupKey(entry)
+}
+
+// ProducerOptions configure a StreamProducer.
+type ProducerOptions func(*StreamProducer)
+
+// WithProducerBatchSize sets how many records are sent per Produce call.
+func WithProducerBatchSize(n int) ProducerOptions {
+	return func(p *StreamProducer) {
+		p.batchSize = n
+	}
+}
+
+// WithProducerRetry retries a failed batch up to attempts times in total,
+// waiting backoff(n) before retry n (no wait when nil).
+func WithProducerRetry(attempts int, backoff func(attempt int) time.Duration) ProducerOptions {
+	return func(p *StreamProducer) {
+		p.attempts = attempts
+		p.backoff = backoff
+	}
+}
+
+// StreamProducer produces events to streamkit spaces in the polymorphic
+// envelope StreamProcessorBase consumes. Records carry an idempotency key so
+// a retried batch never writes an event twice: before retrying, the producer
+// reads the segment after the last sequence it knows and skips records that
+// already landed. Publishes to different segments run concurrently.
+type StreamProducer struct {
+	factory   streamkit.ClientFactory
+	storeID   uuid.UUID
+	batchSize int
+	attempts  int
+	backoff   func(attempt int) time.Duration
+
+	// mu guards client and segments; it is never held across I/O
+	mu       sync.Mutex
+	client   streamkit.Client
+	segments map[segmentRef]*segmentState
+}
+
+type segmentRef struct {
+	space   string
+	segment string
+}
+
+// segmentState tracks what the producer knows about one segment. mu is held
+// for a whole produce, serializing writes to the segment.
+type segmentState struct {
+	mu       sync.Mutex
+	known    bool
+	sequence uint64
+	// unconfirmed is set when a produce failed after records may have landed
+	unconfirmed bool
+}
+
+// NewStreamProducer creates a producer for storeID using clients from factory.
+func NewStreamProducer(factory streamkit.ClientFactory, storeID uuid.UUID, opts ...ProducerOptions) *StreamProducer {
+	p := &StreamProducer{
+		factory:   factory,
+		storeID:   storeID,
+		batchSize: DefaultProducerBatchSize,
+		attempts:  3,
+		backoff:   ExponentialBackoff(100*time.Millisecond, 5*time.Second),
+		segments:  make(map[segmentRef]*segmentState),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.batchSize <= 0 {
+		p.batchSize = DefaultProducerBatchSize
+	}
+	return p
+}
+
+// Publish produces events to segment of every space returned by their
+// GetSpaces, in order. The tenant in ctx, if any, is recorded under
+// TenantMetadataKey and the active span as W3C trace context.
+func Publish[T api.Consumable](ctx context.Context, p *StreamProducer, segment string, events ...T) error {
+	if segment == "" {
+		return errors.New("segment must not be empty")
+	}
+	bySpace := make(map[string][]*streamkit.Record)
+	var spaces []string
+	for _, event := range events {
+		record, err := newEventRecord(ctx, event)
+		if err != nil {
+			return err
+		}
+		eventSpaces := event.GetSpaces()
+		if len(eventSpaces) == 0 {
+			return fmt.Errorf("event %q has no spaces", event.GetDiscriminator())
+		}
+		for _, space := range eventSpaces {
+			if _, ok := bySpace[space]; !ok {
+				spaces = append(spaces, space)
+			}
+			bySpace[space] = append(bySpace[space], record)
+		}
+	}
+
+	for _, space := range spaces {
+		for _, batch := range splitBatches(bySpace[space], p.batchSize) {
+			if err := p.produce(ctx, space, segment, batch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newEventRecord wraps event in a polymorphic envelope. The sequence is
+// assigned when the record is produced.
+func newEventRecord(ctx context.Context, event api.Consumable) (*streamkit.Record, error) {
+	payload, err := polymorphic.MarshalPolymorphicJSON(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event %q: %w", event.GetDiscriminator(), err)
+	}
+	key := ""
+	if idem, ok := any(event).(IdempotentEvent); ok {
+		key = idem.GetIdempotencyKey()
+	}
+	if key == "" {
+		key = uuid.NewString()
+	}
+	metadata := map[string]string{IdempotencyMetadataKey: key}
+	if tenantID, err := meshctx.TenantIDFromContext(ctx); err == nil {
+		metadata[TenantMetadataKey] = tenantID.String()
+	}
+	tracing.InjectMetadata(ctx, metadata)
+	return &streamkit.Record{Payload: payload, Metadata: metadata}, nil
+}
+
+func splitBatches(records []*streamkit.Record, size int) [][]*streamkit.Record {
+	var batches [][]*streamkit.Record
+	for len(records) > size {
+		batches = append(batches, records[:size:size])
+		records = records[size:]
+	}
+	if len(records) > 0 {
+		batches = append(batches, records)
+	}
+	return batches
+}
+
+// produce writes batch to space/segment, retrying as configured. Producers
+// sharing a segment are serialized per StreamProducer only; concurrent
+// producers should write to distinct segments.
+func (p *StreamProducer) produce(ctx context.Context, space, segment string, batch []*streamkit.Record) error {
+	ref := segmentRef{space: space, segment: segment}
+	state := p.segment(ref)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	attempts := max(p.attempts, 1)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 && p.backoff != nil {
+			if waitErr := sleepContext(ctx, p.backoff(attempt-1)); waitErr != nil {
+				return errors.Join(err, waitErr)
+			}
+		}
+
+		var client streamkit.Client
+		if client, err = p.getClient(ctx); err != nil {
+			continue
+		}
+
+		pending := batch
+		switch {
+		case !state.known:
+			if state.sequence, err = p.lastSequence(ctx, client, ref); err != nil {
+				p.dropClient()
+				continue
+			}
+			state.known = true
+		case state.unconfirmed:
+			// the previous attempt may have partially succeeded
+			var written *collections.HashSet[string]
+			if state.sequence, written, err = p.readTail(ctx, client, ref, state.sequence); err != nil {
+				p.dropClient()
+				continue
+			}
+			state.unconfirmed = false
+			pending = unwrittenRecords(batch, written)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		records := make([]*streamkit.Record, len(pending))
+		for i, record := range pending {
+			records[i] = &streamkit.Record{Sequence: state.sequence + uint64(i) + 1, Payload: record.Payload, Metadata: record.Metadata}
+		}
+		results := client.Produce(ctx, p.storeID, space, segment, enumerators.Slice(records))
+		if err = enumerators.Consume(results); err != nil {
+			p.dropClient()
+			state.unconfirmed = true
+			continue
+		}
+		state.sequence = records[len(records)-1].Sequence
+		return nil
+	}
+	return fmt.Errorf("failed to produce %d records to %s/%s: %w", len(batch), space, segment, err)
+}
+
+// segment returns the state of ref, creating it on first use.
+func (p *StreamProducer) segment(ref segmentRef) *segmentState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state, ok := p.segments[ref]
+	if !ok {
+		state = &segmentState{}
+		p.segments[ref] = state
+	}
+	return state
+}
+
+// getClient returns the cached client, creating one from the factory when
+// needed.
+func (p *StreamProducer) getClient(ctx context.Context) (streamkit.Client, error) {
+	p.mu.Lock()
+	client := p.client
+	p.mu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := p.factory.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream client: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		p.client = client
+	}
+	return p.client, nil
+}
+
+// dropClient discards the cached client after a failure so the next attempt
+// gets a fresh one from the factory.
+func (p *StreamProducer) dropClient() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.client = nil
+}
+
+// lastSequence returns the last sequence written to ref, or zero when the
+// segment is empty.
+func (p *StreamProducer) lastSequence(ctx context.Context, client streamkit.Client, ref segmentRef) (uint64, error) {
+	last, err := client.Peek(ctx, p.storeID, ref.space, ref.segment)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read segment %s/%s: %w", ref.space, ref.segment, err)
+	}
+	if last == nil {
+		return 0, nil
+	}
+	return last.Sequence, nil
+}
+
+// readTail returns the last sequence of ref and the idempotency keys written
+// after sequence after.
+func (p *StreamProducer) readTail(ctx context.Context, client streamkit.Client, ref segmentRef, after uint64) (uint64, *collections.HashSet[string], error) {
+	last := after
+	written := collections.NewHashSet[string]()
+	args := &streamkit.ConsumeSegment{Space: ref.space, Segment: ref.segment, MinSequence: after + 1}
+	err := enumerators.ForEach(client.ConsumeSegment(ctx, p.storeID, args), func(entry *streamkit.Entry) error {
+		if entry == nil || entry.Sequence <= after {
+			return nil
+		}
+		last = max(last, entry.Sequence)
+		if key := entry.Metadata[IdempotencyMetadataKey]; key != "" {
+			written.Add(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read segment %s/%s: %w", ref.space, ref.segment, err)
+	}
+	return last, written, nil
+}
+
+func unwrittenRecords(batch []*streamkit.Record, written *collections.HashSet[string]) []*streamkit.Record {
+	pending := make([]*streamkit.Record, 0, len(batch))
+	for _, record := range batch {
+		if !written.Contains(record.Metadata[IdempotencyMetadataKey]) {
+			pending = append(pending, record)
+		}
+	}
+	return pending
+}
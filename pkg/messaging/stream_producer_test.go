@@ -0,0 +1,230 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/collections"
+	"github.com/fgrzl/enumerators"
+	"github.com/fgrzl/json/polymorphic"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/meshctx"
+	"github.com/hydn-co/mesh-sdk/pkg/messaging/memstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type idempotentTestEvent struct {
+	testEvent
+}
+
+func (*idempotentTestEvent) GetIdempotencyKey() string { return "order-42" }
+
+type failingClientFactory struct {
+	calls int
+}
+
+func (f *failingClientFactory) Get(ctx context.Context) (streamkit.Client, error) {
+	f.calls++
+	return nil, errors.New("stream unavailable")
+}
+
+func TestShouldWrapEventInPolymorphicEnvelope(t *testing.T) {
+	// Arrange
+	tenantID := uuid.New()
+	ctx := meshctx.WithTenantID(context.Background(), tenantID)
+
+	// Act
+	record, err := newEventRecord(ctx, &testEvent{Value: "hello"})
+
+	// Assert
+	require.NoError(t, err)
+	envelope, err := polymorphic.UnmarshalPolymorphicJSON(record.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, &testEvent{Value: "hello"}, envelope.Content)
+	assert.NotEmpty(t, record.Metadata[IdempotencyMetadataKey])
+	assert.Equal(t, tenantID.String(), record.Metadata[TenantMetadataKey])
+}
+
+func TestShouldUseEventIdempotencyKey(t *testing.T) {
+	// Act
+	record, err := newEventRecord(context.Background(), &idempotentTestEvent{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "order-42", record.Metadata[IdempotencyMetadataKey])
+	assert.Equal(t, "order-42", IdempotencyDedupKey(&streamkit.Entry{Metadata: record.Metadata}))
+}
+
+func TestShouldSplitRecordsIntoBatches(t *testing.T) {
+	// Arrange
+	records := make([]*streamkit.Record, 5)
+
+	// Act
+	batches := splitBatches(records, 2)
+
+	// Assert
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[2], 1)
+}
+
+func TestShouldSkipRecordsAlreadyWritten(t *testing.T) {
+	// Arrange
+	first := &streamkit.Record{Metadata: map[string]string{IdempotencyMetadataKey: "a"}}
+	second := &streamkit.Record{Metadata: map[string]string{IdempotencyMetadataKey: "b"}}
+	written := collections.NewHashSet[string]()
+	written.Add("a")
+
+	// Act
+	pending := unwrittenRecords([]*streamkit.Record{first, second}, written)
+
+	// Assert
+	assert.Equal(t, []*streamkit.Record{second}, pending)
+}
+
+func TestShouldRetryPublishUntilAttemptsAreExhausted(t *testing.T) {
+	// Arrange
+	factory := &failingClientFactory{}
+	producer := NewStreamProducer(factory, uuid.New(), WithProducerRetry(3, nil))
+
+	// Act
+	err := Publish(context.Background(), producer, "segment", &testEvent{Value: "hello"})
+
+	// Assert
+	assert.ErrorContains(t, err, "stream unavailable")
+	assert.Equal(t, 3, factory.calls)
+}
+
+func TestShouldRejectPublishWithoutSegment(t *testing.T) {
+	// Arrange
+	producer := NewStreamProducer(&failingClientFactory{}, uuid.New())
+
+	// Act
+	err := Publish(context.Background(), producer, "", &testEvent{})
+
+	// Assert
+	assert.Error(t, err)
+}
+
+// memProducerClient serves a producer from a memstream.Client. The embedded
+// nil streamkit.Client stands in for methods the producer never calls.
+type memProducerClient struct {
+	streamkit.Client
+	mem *memstream.Client
+
+	mu sync.Mutex
+	// lostAcks makes that many produces land but report failure, as a
+	// connection dropped before the acknowledgement would
+	lostAcks int
+	// blocked holds produces to a segment until the channel is closed
+	blocked map[string]chan struct{}
+}
+
+func (c *memProducerClient) Get(context.Context) (streamkit.Client, error) { return c, nil }
+
+func (c *memProducerClient) Peek(ctx context.Context, storeID uuid.UUID, space, segment string) (*streamkit.Entry, error) {
+	return c.mem.Peek(ctx, storeID, space, segment)
+}
+
+func (c *memProducerClient) ConsumeSegment(ctx context.Context, storeID uuid.UUID, args *streamkit.ConsumeSegment) enumerators.Enumerator[*streamkit.Entry] {
+	return c.mem.ConsumeSegment(ctx, storeID, args)
+}
+
+func (c *memProducerClient) Produce(ctx context.Context, storeID uuid.UUID, space, segment string, records enumerators.Enumerator[*streamkit.Record]) enumerators.Enumerator[*streamkit.SegmentStatus] {
+	c.mu.Lock()
+	block := c.blocked[segment]
+	lost := c.lostAcks > 0
+	if lost {
+		c.lostAcks--
+	}
+	c.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	results := c.mem.Produce(ctx, storeID, space, segment, records)
+	if lost {
+		_ = enumerators.Consume(results)
+		return enumerators.Error[*streamkit.SegmentStatus](errors.New("connection lost"))
+	}
+	return results
+}
+
+func segmentValues(t *testing.T, mem *memstream.Client, storeID uuid.UUID, segment string) []string {
+	t.Helper()
+	var values []string
+	for _, entry := range mem.Entries(storeID, "test-space") {
+		if entry.Segment != segment {
+			continue
+		}
+		envelope, err := polymorphic.UnmarshalPolymorphicJSON(entry.Payload)
+		require.NoError(t, err)
+		values = append(values, envelope.Content.(*testEvent).Value)
+	}
+	return values
+}
+
+func TestShouldContinueExistingSegmentSequence(t *testing.T) {
+	// Arrange
+	client := &memProducerClient{mem: memstream.New()}
+	storeID := uuid.New()
+	client.mem.Append(storeID, "test-space", "segment", &streamkit.Record{Payload: []byte("{}")})
+	producer := NewStreamProducer(client, storeID)
+
+	// Act
+	err := Publish(context.Background(), producer, "segment", &testEvent{Value: "a"}, &testEvent{Value: "b"})
+	require.NoError(t, err)
+	err = Publish(context.Background(), producer, "segment", &testEvent{Value: "c"})
+
+	// Assert
+	require.NoError(t, err)
+	entries := client.mem.Entries(storeID, "test-space")
+	require.Len(t, entries, 4)
+	assert.Equal(t, uint64(4), entries[3].Sequence)
+}
+
+func TestShouldNotRewriteRecordsThatLandedBeforeRetry(t *testing.T) {
+	// Arrange
+	client := &memProducerClient{mem: memstream.New(), lostAcks: 1}
+	storeID := uuid.New()
+	producer := NewStreamProducer(client, storeID, WithProducerRetry(2, nil))
+
+	// Act
+	err := Publish(context.Background(), producer, "segment", &testEvent{Value: "a"}, &testEvent{Value: "b"})
+	require.NoError(t, err)
+	err = Publish(context.Background(), producer, "segment", &testEvent{Value: "c"})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, segmentValues(t, client.mem, storeID, "segment"))
+}
+
+func TestShouldPublishToOtherSegmentsWhileOneIsBlocked(t *testing.T) {
+	// Arrange
+	release := make(chan struct{})
+	client := &memProducerClient{mem: memstream.New(), blocked: map[string]chan struct{}{"slow": release}}
+	storeID := uuid.New()
+	producer := NewStreamProducer(client, storeID)
+	slow := make(chan error, 1)
+	go func() { slow <- Publish(context.Background(), producer, "slow", &testEvent{Value: "slow"}) }()
+
+	// Act
+	fast := make(chan error, 1)
+	go func() { fast <- Publish(context.Background(), producer, "fast", &testEvent{Value: "fast"}) }()
+
+	// Assert
+	select {
+	case err := <-fast:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("publish to another segment waited for the blocked segment")
+	}
+	close(release)
+	require.NoError(t, <-slow)
+	assert.Equal(t, []string{"slow"}, segmentValues(t, client.mem, storeID, "slow"))
+}
@@ -0,0 +1,152 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hydn-co/mesh-sdk/pkg/lifecycle"
+)
+
+// ConsumerState is the lifecycle state of a stream consumer.
+type ConsumerState int
+
+const (
+	ConsumerStopped ConsumerState = iota
+	ConsumerRunning
+	ConsumerRestarting
+	ConsumerFailed
+	// ConsumerStandby waits for the consumer group lease held by another
+	// replica.
+	ConsumerStandby
+)
+
+func (s ConsumerState) String() string {
+	switch s {
+	case ConsumerStopped:
+		return "stopped"
+	case ConsumerRunning:
+		return "running"
+	case ConsumerRestarting:
+		return "restarting"
+	case ConsumerFailed:
+		return "failed"
+	case ConsumerStandby:
+		return "standby"
+	default:
+		return fmt.Sprintf("ConsumerState(%d)", int(s))
+	}
+}
+
+// ConsumerStatus is a point-in-time view of a stream consumer for
+// supervisors and health checks.
+type ConsumerStatus struct {
+	State       ConsumerState
+	Restarts    int
+	StartedAt   time.Time
+	ErrorCount  uint64
+	LastError   error
+	LastErrorAt time.Time
+	// MissedNotifications counts polls that found entries no segment
+	// notification had announced.
+	MissedNotifications uint64
+}
+
+// WithRestartPolicy runs the consumer under policy. With a Restart action the
+// consumer is restarted after runConsumer fails, waiting policy.Backoff
+// between attempts (exponential from 500ms up to 30s when nil) and giving up
+// after policy.MaxRestarts restarts (-1 for unlimited). Any other action
+// leaves the consumer failed after the first error, which is the default.
+func WithRestartPolicy(policy lifecycle.Policy) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.restartPolicy = policy
+	}
+}
+
+// Status returns the consumer's current status.
+func (p *StreamProcessorBase) Status() ConsumerStatus {
+	p.mu.Lock()
+	status := p.status
+	p.mu.Unlock()
+
+	p.errStats.mu.Lock()
+	status.ErrorCount = p.errStats.count
+	status.LastError = p.errStats.last
+	status.LastErrorAt = p.errStats.lastAt
+	p.errStats.mu.Unlock()
+	status.MissedNotifications = p.missedNotifications.Load()
+	return status
+}
+
+func (p *StreamProcessorBase) setState(state ConsumerState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.State = state
+}
+
+// runAttempt runs the consumer once, behind the group lease when a
+// whole-processor consumer group is configured.
+func (p *StreamProcessorBase) runAttempt(ctx context.Context) error {
+	if p.group != nil && !p.group.PerSpace {
+		return p.runLeader(ctx)
+	}
+	return p.runConsumer(ctx)
+}
+
+// startState is the state reported while an attempt starts: consumer group
+// members stand by until they win a lease.
+func (p *StreamProcessorBase) startState() ConsumerState {
+	if p.group != nil {
+		return ConsumerStandby
+	}
+	return ConsumerRunning
+}
+
+// superviseConsumer runs runConsumer until ctx is canceled, restarting it as
+// the restart policy allows. Each attempt gets its own context so the space
+// subscriptions of a failed attempt are released before the next one.
+func (p *StreamProcessorBase) superviseConsumer(ctx context.Context) {
+	policy := p.restartPolicy
+	backoff := policy.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff(500*time.Millisecond, 30*time.Second)
+	}
+
+	restarts := 0
+	for {
+		p.setState(p.startState())
+		attemptCtx, cancel := context.WithCancel(ctx)
+		go p.flushOnInterval(attemptCtx)
+		err := p.runAttempt(attemptCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			p.setState(ConsumerStopped)
+			return
+		}
+		if err == nil {
+			p.setState(ConsumerStopped)
+			return
+		}
+		p.reportPassError(ctx, err)
+
+		restarts++
+		if policy.Action != lifecycle.Restart || (policy.MaxRestarts >= 0 && restarts > policy.MaxRestarts) {
+			p.log().ErrorContext(ctx, "stream consumer failed", "restarts", restarts-1, "error", err)
+			p.setState(ConsumerFailed)
+			return
+		}
+
+		p.mu.Lock()
+		p.status.State = ConsumerRestarting
+		p.status.Restarts = restarts
+		p.mu.Unlock()
+
+		wait := backoff(restarts)
+		p.log().WarnContext(ctx, "restarting stream consumer", "attempt", restarts, "backoff", wait, "error", err)
+		if sleepContext(ctx, wait) != nil {
+			p.setState(ConsumerStopped)
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/lifecycle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFailingConsumer(calls *atomic.Int32) *StreamProcessorBase {
+	p := NewStreamProcessorBase(nil, uuid.New())
+	p.RegisterSpaces("test-space")
+	p.RegisterOffsetLoader(func(ctx context.Context) (*ConsumerOffset, error) {
+		calls.Add(1)
+		return nil, errors.New("stream unavailable")
+	})
+	return p
+}
+
+func TestShouldFailConsumerWithoutRestartPolicy(t *testing.T) {
+	// Arrange
+	var calls atomic.Int32
+	p := newFailingConsumer(&calls)
+
+	// Act
+	require.NoError(t, p.StartConsumer(context.Background()))
+
+	// Assert
+	require.Eventually(t, func() bool { return p.Status().State == ConsumerFailed }, time.Second, 5*time.Millisecond)
+	status := p.Status()
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, 0, status.Restarts)
+	assert.ErrorContains(t, status.LastError, "stream unavailable")
+	assert.False(t, status.LastErrorAt.IsZero())
+}
+
+func TestShouldRestartConsumerUpToMaxRestarts(t *testing.T) {
+	// Arrange
+	var calls atomic.Int32
+	p := newFailingConsumer(&calls)
+	policy := lifecycle.RestartPolicy(2, func(int) time.Duration { return time.Millisecond })
+
+	// Act
+	require.NoError(t, p.StartConsumer(context.Background(), WithRestartPolicy(policy)))
+
+	// Assert
+	require.Eventually(t, func() bool { return p.Status().State == ConsumerFailed }, time.Second, 5*time.Millisecond)
+	status := p.Status()
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, 2, status.Restarts)
+	assert.Equal(t, uint64(3), status.ErrorCount)
+}
+
+func TestShouldReportStoppedWhenStoppedDuringBackoff(t *testing.T) {
+	// Arrange
+	var calls atomic.Int32
+	p := newFailingConsumer(&calls)
+	policy := lifecycle.RestartPolicy(-1, func(int) time.Duration { return time.Hour })
+	require.NoError(t, p.StartConsumer(context.Background(), WithRestartPolicy(policy)))
+	require.Eventually(t, func() bool { return p.Status().State == ConsumerRestarting }, time.Second, 5*time.Millisecond)
+
+	// Act
+	err := p.Stop(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, ConsumerStopped, p.Status().State)
+	assert.Equal(t, int32(1), calls.Load())
+}
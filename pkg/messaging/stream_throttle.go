@@ -0,0 +1,216 @@
+package messaging
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveThrottle lowers the consumption rate while handlers are slow or
+// failing and raises it again once they recover. Every Window entries the
+// rate is halved if the average handling latency exceeds TargetLatency or the
+// error rate exceeds MaxErrorRate, and otherwise increased by a tenth of
+// MaxRate, staying between MinRate and MaxRate entries per second.
+type AdaptiveThrottle struct {
+	MinRate float64
+	MaxRate float64
+	// TargetLatency is the tolerated average handling latency. Zero
+	// disables throttling on latency.
+	TargetLatency time.Duration
+	// MaxErrorRate is the tolerated fraction of failed entries, above 0 and
+	// below 1. Zero disables throttling on errors; use a small fraction to
+	// throttle on almost any failure.
+	MaxErrorRate float64
+	// Window is the number of entries between adjustments. The default is 20.
+	Window int
+}
+
+// WithRateLimit limits consumption to perSecond entries per second with
+// bursts of up to burst entries, using a token bucket.
+func WithRateLimit(perSecond float64, burst int) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		if perSecond <= 0 {
+			return
+		}
+		p.ensureThrottle().bucket = newTokenBucket(perSecond, burst)
+	}
+}
+
+// WithMaxInFlight limits how many entries are handled concurrently. It only
+// matters with WithWorkerPool or WithSpaceWorkers; the sequential consumer
+// handles one entry at a time.
+func WithMaxInFlight(n int) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		if n <= 0 {
+			return
+		}
+		p.ensureThrottle().slots = make(chan struct{}, n)
+	}
+}
+
+// WithAdaptiveThrottle adjusts the rate limit from handler latency and error
+// rate. Without WithRateLimit consumption starts at cfg.MaxRate.
+func WithAdaptiveThrottle(cfg AdaptiveThrottle) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		if cfg.MaxRate <= 0 {
+			return
+		}
+		if cfg.MinRate <= 0 || cfg.MinRate > cfg.MaxRate {
+			cfg.MinRate = math.Min(1, cfg.MaxRate)
+		}
+		if cfg.Window <= 0 {
+			cfg.Window = 20
+		}
+		t := p.ensureThrottle()
+		if t.bucket == nil {
+			t.bucket = newTokenBucket(cfg.MaxRate, 0)
+		}
+		t.adaptive = &adaptiveState{cfg: cfg}
+	}
+}
+
+// RateLimit returns the current rate limit in entries per second, or 0 when
+// consumption is not rate limited.
+func (p *StreamProcessorBase) RateLimit() float64 {
+	if p.throttle == nil || p.throttle.bucket == nil {
+		return 0
+	}
+	return p.throttle.bucket.limit()
+}
+
+func (p *StreamProcessorBase) ensureThrottle() *throttle {
+	if p.throttle == nil {
+		p.throttle = &throttle{}
+	}
+	return p.throttle
+}
+
+// throttle gates entry handling by rate and concurrency.
+type throttle struct {
+	bucket   *tokenBucket
+	slots    chan struct{}
+	adaptive *adaptiveState
+}
+
+// run waits for a token and a free slot, then calls fn and feeds its latency
+// and outcome to the adaptive state.
+func (t *throttle) run(ctx context.Context, fn func() error) error {
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+			defer func() { <-t.slots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if t.bucket != nil {
+		if err := t.bucket.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	err := fn()
+	if t.adaptive != nil {
+		if rate, changed := t.adaptive.observe(time.Since(start), err != nil, t.bucket.limit()); changed {
+			t.bucket.setLimit(rate)
+		}
+	}
+	return err
+}
+
+// tokenBucket refills at rate tokens per second up to burst tokens.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket. A burst of zero or less allows one
+// second's worth of tokens, and at least one.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(1, math.Floor(rate))
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// refill adds the tokens accrued since the last call. The caller must hold
+// b.mu.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		b.refill(time.Now())
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *tokenBucket) limit() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+func (b *tokenBucket) setLimit(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.rate = rate
+}
+
+// adaptiveState accumulates one window of observations.
+type adaptiveState struct {
+	cfg AdaptiveThrottle
+
+	mu      sync.Mutex
+	count   int
+	failed  int
+	latency time.Duration
+}
+
+// observe records one entry and, at the end of a window, returns the new rate
+// and whether it differs from current.
+func (a *adaptiveState) observe(d time.Duration, failed bool, current float64) (float64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.count++
+	a.latency += d
+	if failed {
+		a.failed++
+	}
+	if a.count < a.cfg.Window {
+		return current, false
+	}
+
+	avg := a.latency / time.Duration(a.count)
+	errRate := float64(a.failed) / float64(a.count)
+	a.count, a.failed, a.latency = 0, 0, 0
+
+	overloaded := (a.cfg.TargetLatency > 0 && avg > a.cfg.TargetLatency) ||
+		(a.cfg.MaxErrorRate > 0 && errRate > a.cfg.MaxErrorRate)
+	rate := current + a.cfg.MaxRate/10
+	if overloaded {
+		rate = current / 2
+	}
+	rate = math.Max(a.cfg.MinRate, math.Min(a.cfg.MaxRate, rate))
+	return rate, rate != current
+}
@@ -0,0 +1,132 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldLimitEntriesToRate(t *testing.T) {
+	// Arrange
+	p, calls := newFailingProcessor(t, 0)
+	WithRateLimit(50, 1)(p)
+	start := time.Now()
+
+	// Act
+	for i := 1; i <= 6; i++ {
+		require.NoError(t, p.processEntry(context.Background(), newTestEntry(t, uint64(i), "a")))
+	}
+
+	// Assert
+	assert.Equal(t, 6, *calls)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestShouldStopWaitingForTokenWhenCanceled(t *testing.T) {
+	// Arrange
+	p, calls := newFailingProcessor(t, 0)
+	WithRateLimit(0.01, 1)(p)
+	require.NoError(t, p.processEntry(context.Background(), newTestEntry(t, 1, "a")))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err := p.processEntry(ctx, newTestEntry(t, 2, "a"))
+
+	// Assert
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestShouldLimitEntriesInFlight(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	WithMaxInFlight(2)(p)
+	var current, peak atomic.Int32
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		n := current.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		current.Add(-1)
+		return nil
+	}))
+
+	// Act
+	var wg sync.WaitGroup
+	for i := 1; i <= 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, p.processEntry(context.Background(), newTestEntry(t, uint64(i), "a")))
+		}(i)
+	}
+	wg.Wait()
+
+	// Assert
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestShouldHalveRateWhenHandlersFail(t *testing.T) {
+	// Arrange
+	state := &adaptiveState{cfg: AdaptiveThrottle{MinRate: 1, MaxRate: 100, MaxErrorRate: 0.1, Window: 2}}
+
+	// Act
+	_, changedEarly := state.observe(time.Millisecond, true, 100)
+	rate, changed := state.observe(time.Millisecond, false, 100)
+
+	// Assert
+	assert.False(t, changedEarly)
+	assert.True(t, changed)
+	assert.Equal(t, 50.0, rate)
+}
+
+func TestShouldIgnoreErrorsWhenMaxErrorRateIsZero(t *testing.T) {
+	// Arrange
+	state := &adaptiveState{cfg: AdaptiveThrottle{MinRate: 1, MaxRate: 100, Window: 1}}
+
+	// Act
+	rate, changed := state.observe(time.Millisecond, true, 50)
+
+	// Assert
+	assert.True(t, changed)
+	assert.Equal(t, 60.0, rate)
+}
+
+func TestShouldRaiseRateWhenHandlersRecover(t *testing.T) {
+	// Arrange
+	state := &adaptiveState{cfg: AdaptiveThrottle{MinRate: 1, MaxRate: 100, TargetLatency: time.Second, Window: 1}}
+
+	// Act
+	rate, changed := state.observe(time.Millisecond, false, 50)
+
+	// Assert
+	assert.True(t, changed)
+	assert.Equal(t, 60.0, rate)
+}
+
+func TestShouldApplyAdaptiveRateToProcessor(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	WithAdaptiveThrottle(AdaptiveThrottle{MinRate: 10, MaxRate: 1000, MaxErrorRate: 0.5, Window: 1})(p)
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		return errors.New("rate limited")
+	}))
+
+	// Act
+	_ = p.processEntry(context.Background(), newTestEntry(t, 1, "a"))
+
+	// Assert
+	assert.Equal(t, 500.0, p.RateLimit())
+}
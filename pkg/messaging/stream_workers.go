@@ -0,0 +1,196 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/fgrzl/enumerators"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+)
+
+// partitionQueueSize bounds how far the dispatcher may run ahead of a busy
+// partition worker.
+const partitionQueueSize = 64
+
+// PartitionKeyFunc maps an entry to the key that decides its worker. Entries
+// with equal keys are handled in order by the same worker.
+type PartitionKeyFunc = func(*streamkit.Entry) string
+
+// WithSpaceWorkers consumes every space in its own goroutine with its own
+// Consume pass, so a slow space cannot hold back the others.
+func WithSpaceWorkers() ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.spaceWorkers = true
+	}
+}
+
+// WithWorkerPool handles entries on a bounded pool of workers, partitioned by
+// key. Ordering is preserved within a partition and offsets only advance past
+// entries whose predecessors in the same space have completed. A nil key
+// partitions by space. When a handler fails the pass is abandoned and entries
+// after the last committed offset are redelivered, so handlers must tolerate
+// at-least-once delivery.
+func WithWorkerPool(workers int, key PartitionKeyFunc) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.workers = workers
+		p.partitionKey = key
+	}
+}
+
+// runSpaceWorkers runs one consume loop per space and returns when the first
+// of them exits, stopping the rest.
+func (p *StreamProcessorBase) runSpaceWorkers(ctx context.Context, spaces []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(spaces))
+	var wg sync.WaitGroup
+	for _, space := range spaces {
+		wg.Add(1)
+		go func(space string) {
+			defer wg.Done()
+			errs <- p.consumeLoop(ctx, []string{space}, p.consumeSequential)
+		}(space)
+	}
+
+	err := <-errs
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// consumePartitioned dispatches a pass over the worker pool.
+func (p *StreamProcessorBase) consumePartitioned(ctx context.Context, enumerator enumerators.Enumerator[*streamkit.Entry]) error {
+	passCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		errOnce sync.Once
+		passErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			passErr = err
+			cancel()
+		})
+	}
+
+	commits := newCommitQueue()
+	queues := make([]chan *pendingEntry, p.workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *pendingEntry, partitionQueueSize)
+		wg.Add(1)
+		go func(queue <-chan *pendingEntry) {
+			defer wg.Done()
+			for pe := range queue {
+				if passCtx.Err() != nil {
+					continue
+				}
+				if err := p.processEntry(passCtx, pe.entry); err != nil {
+					fail(err)
+					continue
+				}
+				if commits.complete(pe, p.recordEntries) {
+					if err := p.flushPending(passCtx); err != nil {
+						fail(err)
+					}
+				}
+			}
+		}(queues[i])
+	}
+
+	dispatchErr := enumerators.ForEach(enumerator, func(entry *streamkit.Entry) error {
+		if entry == nil {
+			return nil
+		}
+		pe := commits.add(entry)
+		select {
+		case queues[p.partition(entry)] <- pe:
+			return nil
+		case <-passCtx.Done():
+			return passCtx.Err()
+		}
+	})
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	if passErr != nil {
+		p.reportPassError(ctx, passErr)
+	} else if !errors.Is(dispatchErr, context.Canceled) {
+		p.reportPassError(ctx, dispatchErr)
+	}
+
+	return p.flushPending(ctx)
+}
+
+// partition returns the worker index for entry.
+func (p *StreamProcessorBase) partition(entry *streamkit.Entry) int {
+	key := entry.Space
+	if p.partitionKey != nil {
+		key = p.partitionKey(entry)
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(p.workers))
+}
+
+// pendingEntry is an entry dispatched to a worker but not yet committed.
+type pendingEntry struct {
+	entry *streamkit.Entry
+	done  bool
+}
+
+// commitQueue tracks dispatched entries per space in dispatch order so the
+// committed offset only moves past a contiguous run of completed entries.
+type commitQueue struct {
+	mu     sync.Mutex
+	spaces map[string][]*pendingEntry
+}
+
+func newCommitQueue() *commitQueue {
+	return &commitQueue{spaces: make(map[string][]*pendingEntry)}
+}
+
+func (q *commitQueue) add(entry *streamkit.Entry) *pendingEntry {
+	pe := &pendingEntry{entry: entry}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.spaces[entry.Space] = append(q.spaces[entry.Space], pe)
+	return pe
+}
+
+// complete marks pe done and passes the entries that can now be committed,
+// oldest first, to record. record runs under the queue lock so offsets
+// within a space are recorded in order. It is not called while an earlier
+// entry in the space is still running.
+func (q *commitQueue) complete(pe *pendingEntry, record func(committed []*streamkit.Entry) bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pe.done = true
+
+	var committed []*streamkit.Entry
+	queue := q.spaces[pe.entry.Space]
+	for len(queue) > 0 && queue[0].done {
+		committed = append(committed, queue[0].entry)
+		queue = queue[1:]
+	}
+	q.spaces[pe.entry.Space] = queue
+	if len(committed) == 0 {
+		return false
+	}
+	return record(committed)
+}
+
+// recordEntries records the offset of the newest of committed, contiguous
+// entries of one space, and reports whether a full batch is now pending.
+func (p *StreamProcessorBase) recordEntries(committed []*streamkit.Entry) bool {
+	newest := committed[len(committed)-1]
+	due := p.recordOffset(newest.Space, newest.GetSpaceOffset(), len(committed))
+	p.commitLag(committed...)
+	return due
+}
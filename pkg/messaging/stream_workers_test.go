@@ -0,0 +1,125 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/enumerators"
+	"github.com/fgrzl/lexkey"
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWorkerPoolProcessor(t *testing.T, workers int, handler func(context.Context, *testEvent) error) (*StreamProcessorBase, *[]*ConsumerOffset) {
+	t.Helper()
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	require.NoError(t, RegisterStreamHandler(p, handler))
+	var mu sync.Mutex
+	var flushed []*ConsumerOffset
+	WithWorkerPool(workers, func(e *streamkit.Entry) string { return strconv.FormatUint(e.Sequence%2, 10) })(p)
+	WithFlushHook(func(ctx context.Context, off *ConsumerOffset) error {
+		mu.Lock()
+		defer mu.Unlock()
+		flushed = append(flushed, off)
+		return nil
+	})(p)
+	WithBatchSize(100)(p)
+	p.offset = &ConsumerOffset{Offsets: map[string]lexkey.LexKey{"test-space": lexkey.Empty}}
+	return p, &flushed
+}
+
+func TestShouldPreserveOrderWithinPartition(t *testing.T) {
+	// Arrange
+	var mu sync.Mutex
+	seen := map[uint64][]string{}
+	p, flushed := newWorkerPoolProcessor(t, 2, func(ctx context.Context, e *testEvent) error {
+		n, _ := strconv.ParseUint(e.Value, 10, 64)
+		if n%2 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		seen[n%2] = append(seen[n%2], e.Value)
+		mu.Unlock()
+		return nil
+	})
+	entries := make([]*streamkit.Entry, 0, 10)
+	for i := uint64(1); i <= 10; i++ {
+		entries = append(entries, newTestEntry(t, i, strconv.FormatUint(i, 10)))
+	}
+
+	// Act
+	err := p.consumePartitioned(context.Background(), enumerators.Slice(entries))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "4", "6", "8", "10"}, seen[0])
+	assert.Equal(t, []string{"1", "3", "5", "7", "9"}, seen[1])
+	require.Len(t, *flushed, 1)
+	assert.Equal(t, entries[9].GetSpaceOffset(), (*flushed)[0].Offsets["test-space"])
+}
+
+func TestShouldNotCommitPastFailedEntry(t *testing.T) {
+	// Arrange
+	p, flushed := newWorkerPoolProcessor(t, 2, func(ctx context.Context, e *testEvent) error {
+		if e.Value == "3" {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+	entries := make([]*streamkit.Entry, 0, 6)
+	for i := uint64(1); i <= 6; i++ {
+		entries = append(entries, newTestEntry(t, i, strconv.FormatUint(i, 10)))
+	}
+
+	// Act
+	err := p.consumePartitioned(context.Background(), enumerators.Slice(entries))
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, *flushed, 1)
+	committed := (*flushed)[0].Offsets["test-space"]
+	assert.Contains(t, []string{string(entries[0].GetSpaceOffset()), string(entries[1].GetSpaceOffset())}, string(committed),
+		"offset must not advance past the failed entry")
+}
+
+func TestShouldCommitContiguousCompletionsOnly(t *testing.T) {
+	// Arrange
+	q := newCommitQueue()
+	first := q.add(newTestEntry(t, 1, "1"))
+	second := q.add(newTestEntry(t, 2, "2"))
+	third := q.add(newTestEntry(t, 3, "3"))
+	var recorded []int
+	record := func(committed []*streamkit.Entry) bool {
+		recorded = append(recorded, len(committed))
+		return false
+	}
+
+	// Act
+	q.complete(third, record)
+	q.complete(second, record)
+	q.complete(first, record)
+
+	// Assert
+	assert.Equal(t, []int{3}, recorded)
+}
+
+func TestShouldPartitionEntriesWithSameKeyToSameWorker(t *testing.T) {
+	// Arrange
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	WithWorkerPool(4, nil)(p)
+
+	// Act
+	a := p.partition(&streamkit.Entry{Space: "orders", Sequence: 1})
+	b := p.partition(&streamkit.Entry{Space: "orders", Sequence: 2})
+
+	// Assert
+	assert.Equal(t, a, b)
+	assert.GreaterOrEqual(t, a, 0)
+	assert.Less(t, a, 4)
+}
@@ -2,44 +2,127 @@ package messaging
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/fgrzl/messaging"
 	"github.com/google/uuid"
 )
 
 // SubscriptionTracker defines lifecycle methods for managing messaging.Subscriptions.
+// Implementations returned by NewSubscriptionTracker are safe for concurrent use.
 type SubscriptionTracker interface {
 	Track(messaging.Subscription)
 	Untrack(messaging.Subscription)
 	Shutdown(context.Context) error
 }
 
+// RouteTracker is a SubscriptionTracker that also records the route each
+// subscription listens on and reports what it is tracking.
+type RouteTracker interface {
+	SubscriptionTracker
+	TrackRoute(messaging.Route, messaging.Subscription)
+	Count() int
+	Routes() []messaging.Route
+}
+
+type trackedSubscription struct {
+	sub   messaging.Subscription
+	route *messaging.Route
+}
+
 type subscriptionTracker struct {
-	subs map[uuid.UUID]messaging.Subscription
+	mu   sync.Mutex
+	subs map[uuid.UUID]trackedSubscription
 }
 
+// NewSubscriptionTracker returns a tracker that also implements RouteTracker.
 func NewSubscriptionTracker() SubscriptionTracker {
+	return NewRouteTracker()
+}
+
+// NewRouteTracker returns a concurrency-safe RouteTracker.
+func NewRouteTracker() RouteTracker {
 	return &subscriptionTracker{
-		subs: make(map[uuid.UUID]messaging.Subscription),
+		subs: make(map[uuid.UUID]trackedSubscription),
 	}
 }
 
+// Track records sub so it is unsubscribed on Shutdown.
 func (s *subscriptionTracker) Track(sub messaging.Subscription) {
-	s.subs[sub.GetID()] = sub
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[sub.GetID()] = trackedSubscription{sub: sub}
+}
+
+// TrackRoute records sub along with the route it listens on, so the route is
+// reported by Routes.
+func (s *subscriptionTracker) TrackRoute(route messaging.Route, sub messaging.Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[sub.GetID()] = trackedSubscription{sub: sub, route: &route}
 }
 
+// Untrack stops tracking sub and unsubscribes it.
 func (s *subscriptionTracker) Untrack(sub messaging.Subscription) {
+	s.mu.Lock()
 	delete(s.subs, sub.GetID())
+	s.mu.Unlock()
 	_ = sub.Unsubscribe()
 }
 
+// Count returns the number of tracked subscriptions.
+func (s *subscriptionTracker) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
+// Routes returns the routes of subscriptions tracked with TrackRoute. A route
+// appears once per subscription listening on it.
+func (s *subscriptionTracker) Routes() []messaging.Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	routes := make([]messaging.Route, 0, len(s.subs))
+	for _, t := range s.subs {
+		if t.route != nil {
+			routes = append(routes, *t.route)
+		}
+	}
+	return routes
+}
+
+// Shutdown unsubscribes every tracked subscription and returns all failures
+// joined with errors.Join. If ctx is done before every subscription has been
+// unsubscribed, Shutdown returns early with ctx.Err() included; the remaining
+// unsubscribes continue in the background.
 func (s *subscriptionTracker) Shutdown(ctx context.Context) error {
-	for id, sub := range s.subs {
-		if err := sub.Unsubscribe(); err != nil {
-			return fmt.Errorf("failed to unsubscribe from %s subscription %s", id, err)
+	s.mu.Lock()
+	subs := s.subs
+	s.subs = make(map[uuid.UUID]trackedSubscription)
+	s.mu.Unlock()
+
+	var mu sync.Mutex
+	var errs []error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for id, t := range subs {
+			if err := t.sub.Unsubscribe(); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("failed to unsubscribe from %s subscription: %w", id, err))
+				mu.Unlock()
+			}
 		}
-		delete(s.subs, id)
+	}()
+
+	select {
+	case <-done:
+		return errors.Join(errs...)
+	case <-ctx.Done():
+		mu.Lock()
+		defer mu.Unlock()
+		return errors.Join(append(errs, fmt.Errorf("subscription shutdown interrupted: %w", ctx.Err()))...)
 	}
-	return nil
 }
@@ -3,10 +3,15 @@ package messaging
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/fgrzl/messaging"
 	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/testkit"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -118,3 +123,130 @@ func TestShouldOverwriteTrackedSubscriptionWithSameID(t *testing.T) {
 	assert.False(t, sub1.unsubscribeCalled, "first subscription should not be unsubscribed")
 	assert.True(t, sub2.unsubscribeCalled, "second subscription should be unsubscribed")
 }
+
+// blockingSubscription blocks Unsubscribe until release is closed.
+type blockingSubscription struct {
+	id      uuid.UUID
+	release chan struct{}
+}
+
+func (b *blockingSubscription) GetID() uuid.UUID { return b.id }
+
+func (b *blockingSubscription) Unsubscribe() error {
+	<-b.release
+	return nil
+}
+
+func TestShouldUnsubscribeAllAndJoinErrorsWhenShutdownFails(t *testing.T) {
+	// Arrange
+	tracker := NewRouteTracker()
+	err1 := errors.New("first failed")
+	err2 := errors.New("second failed")
+	sub1 := &mockSubscription{id: uuid.New(), unsubscribeErr: err1}
+	sub2 := &mockSubscription{id: uuid.New(), unsubscribeErr: err2}
+	sub3 := &mockSubscription{id: uuid.New()}
+	tracker.Track(sub1)
+	tracker.Track(sub2)
+	tracker.Track(sub3)
+
+	// Act
+	err := tracker.Shutdown(context.Background())
+
+	// Assert
+	require.Error(t, err)
+	assert.ErrorIs(t, err, err1)
+	assert.ErrorIs(t, err, err2)
+	assert.True(t, sub1.unsubscribeCalled)
+	assert.True(t, sub2.unsubscribeCalled)
+	assert.True(t, sub3.unsubscribeCalled)
+	assert.Equal(t, 0, tracker.Count())
+}
+
+func TestShouldReturnWhenShutdownContextExpires(t *testing.T) {
+	// Arrange
+	tracker := NewSubscriptionTracker()
+	sub := &blockingSubscription{id: uuid.New(), release: make(chan struct{})}
+	defer close(sub.release)
+	tracker.Track(sub)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err := tracker.Shutdown(ctx)
+
+	// Assert
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestShouldReportCountAndRoutes(t *testing.T) {
+	// Arrange
+	tracker := NewRouteTracker()
+	route := messaging.NewInternalRoute("test", "route")
+	routed := &mockSubscription{id: uuid.New()}
+	unrouted := &mockSubscription{id: uuid.New()}
+
+	// Act
+	tracker.TrackRoute(route, routed)
+	tracker.Track(unrouted)
+
+	// Assert
+	assert.Equal(t, 2, tracker.Count())
+	assert.Equal(t, []messaging.Route{route}, tracker.Routes())
+
+	tracker.Untrack(routed)
+	assert.Equal(t, 1, tracker.Count())
+	assert.Empty(t, tracker.Routes())
+}
+
+func TestShouldTrackSubscriptionsConcurrently(t *testing.T) {
+	// Arrange
+	tracker := NewRouteTracker()
+	const n = 50
+	var wg sync.WaitGroup
+
+	// Act
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sub := &mockSubscription{id: uuid.New()}
+			tracker.Track(sub)
+			_ = tracker.Routes()
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	assert.Equal(t, n, tracker.Count())
+	require.NoError(t, tracker.Shutdown(context.Background()))
+	assert.Equal(t, 0, tracker.Count())
+}
+
+// plainTracker implements only SubscriptionTracker, as trackers written
+// outside this package do.
+type plainTracker struct {
+	tracked []messaging.Subscription
+}
+
+func (p *plainTracker) Track(sub messaging.Subscription) { p.tracked = append(p.tracked, sub) }
+func (p *plainTracker) Untrack(messaging.Subscription)   {}
+func (p *plainTracker) Shutdown(context.Context) error   { return nil }
+
+func TestShouldTrackWithPlainSubscriptionTracker(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	sub := &mockSubscription{id: uuid.New()}
+	bus.Mock.On("Subscribe", mock.Anything, mock.Anything).Return(sub, nil).Once()
+	p := NewMessageBusProcessorBase(bus)
+	tracker := &plainTracker{}
+	p.SubscriptionTracker = tracker
+
+	// Act
+	_, err := p.RegisterMessageHandler(messaging.NewInternalRoute("test", "message"), func(context.Context, messaging.Message) error {
+		return nil
+	})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []messaging.Subscription{sub}, tracker.tracked)
+}
@@ -0,0 +1,100 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fgrzl/messaging"
+	"github.com/google/uuid"
+	"github.com/hydn-co/mesh-sdk/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestSpanContext() trace.SpanContext {
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{9, 8, 7, 6, 5, 4, 3, 2, 1, 9, 8, 7, 6, 5, 4, 3},
+		SpanID:     trace.SpanID{1, 1, 2, 3, 5, 8, 13, 21},
+		TraceFlags: trace.FlagsSampled,
+	})
+}
+
+// recordSpans routes spans of the global tracer provider to the returned
+// recorder for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestShouldContinueSenderTraceInMessageHandler(t *testing.T) {
+	// Arrange
+	sc := newTestSpanContext()
+	received := natsContext(natsHeader(trace.ContextWithSpanContext(context.Background(), sc)))
+	p := NewMessageBusProcessorBase(nil)
+	var got trace.SpanContext
+	handler := p.trackMessageHandler((&testMessage{}).GetRoute(), func(ctx context.Context, msg messaging.Message) error {
+		got = trace.SpanContextFromContext(ctx)
+		return nil
+	})
+
+	// Act
+	err := handler(received, &testMessage{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, sc.TraceID(), got.TraceID())
+}
+
+func TestShouldStartSpanNamedAfterRouteForBusHandlers(t *testing.T) {
+	// Arrange
+	recorder := recordSpans(t)
+	sc := newTestSpanContext()
+	received := natsContext(natsHeader(trace.ContextWithSpanContext(context.Background(), sc)))
+	p := NewMessageBusProcessorBase(nil)
+	route := (&testRequest{}).GetRoute()
+	handler := p.trackRequestHandler(route, func(ctx context.Context, req messaging.Request) (messaging.Response, error) {
+		return nil, errors.New("boom")
+	})
+
+	// Act
+	_, err := handler(received, &testRequest{})
+
+	// Assert
+	require.Error(t, err)
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "request "+route.String(), spans[0].Name())
+	assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+	assert.Equal(t, sc.SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+}
+
+func TestShouldContinueProducerTraceInStreamHandler(t *testing.T) {
+	// Arrange
+	sc := newTestSpanContext()
+	entry := newTestEntry(t, 1, "hello")
+	entry.Metadata = map[string]string{}
+	tracing.InjectMetadata(trace.ContextWithSpanContext(context.Background(), sc), entry.Metadata)
+	p := NewStreamProcessorBase(nil, uuid.Nil)
+	var got trace.SpanContext
+	require.NoError(t, RegisterStreamHandler(p, func(ctx context.Context, e *testEvent) error {
+		got = trace.SpanContextFromContext(ctx)
+		return nil
+	}))
+
+	// Act
+	err := p.handleEntry(context.Background(), entry)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, sc.TraceID(), got.TraceID())
+}
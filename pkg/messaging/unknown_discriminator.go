@@ -0,0 +1,124 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sync"
+
+	streamkit "github.com/fgrzl/streamkit/pkg/client"
+)
+
+// UnknownDiscriminatorPolicy controls what StreamProcessorBase does with an
+// entry whose discriminator has no registered handler.
+type UnknownDiscriminatorPolicy int
+
+const (
+	// UnknownDiscriminatorFail fails the entry like any other handler error.
+	UnknownDiscriminatorFail UnknownDiscriminatorPolicy = iota
+	// UnknownDiscriminatorSkip counts the entry as skipped and advances past it.
+	UnknownDiscriminatorSkip
+	// UnknownDiscriminatorFallback passes the raw entry to the handler set with
+	// WithUnknownDiscriminatorHandler.
+	UnknownDiscriminatorFallback
+)
+
+func (p UnknownDiscriminatorPolicy) String() string {
+	switch p {
+	case UnknownDiscriminatorFail:
+		return "fail"
+	case UnknownDiscriminatorSkip:
+		return "skip"
+	case UnknownDiscriminatorFallback:
+		return "fallback"
+	default:
+		return fmt.Sprintf("UnknownDiscriminatorPolicy(%d)", int(p))
+	}
+}
+
+// UnknownStreamHandler receives entries with an unregistered discriminator.
+// The payload is not decoded because its type may not be registered locally.
+type UnknownStreamHandler = func(ctx context.Context, discriminator string, entry *streamkit.Entry) error
+
+// WithUnknownDiscriminatorPolicy sets how entries without a registered
+// handler are treated. The default is UnknownDiscriminatorFail.
+func WithUnknownDiscriminatorPolicy(policy UnknownDiscriminatorPolicy) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.unknownPolicy = policy
+	}
+}
+
+// WithUnknownDiscriminatorHandler routes entries without a registered handler
+// to handler.
+func WithUnknownDiscriminatorHandler(handler UnknownStreamHandler) ConsumerOptions {
+	return func(p *StreamProcessorBase) {
+		p.unknownPolicy = UnknownDiscriminatorFallback
+		p.unknownHandler = handler
+	}
+}
+
+// skipCounter counts skipped entries per discriminator.
+type skipCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// add increments discriminator's count and returns the new value.
+func (c *skipCounter) add(discriminator string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]uint64)
+	}
+	c.counts[discriminator]++
+	return c.counts[discriminator]
+}
+
+func (c *skipCounter) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		return map[string]uint64{}
+	}
+	return maps.Clone(c.counts)
+}
+
+// SkippedEvents returns the number of entries skipped under
+// UnknownDiscriminatorSkip, keyed by discriminator.
+func (p *StreamProcessorBase) SkippedEvents() map[string]uint64 {
+	return p.skipped.snapshot()
+}
+
+// peekDiscriminator reads the envelope discriminator without resolving the
+// content type.
+func peekDiscriminator(payload []byte) (string, error) {
+	var envelope struct {
+		Type string `json:"$type"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return "", fmt.Errorf("failed to read envelope discriminator: %w", err)
+	}
+	if envelope.Type == "" {
+		return "", fmt.Errorf("envelope has no discriminator")
+	}
+	return envelope.Type, nil
+}
+
+// handleUnknown applies the unknown-discriminator policy to entry.
+func (p *StreamProcessorBase) handleUnknown(ctx context.Context, discriminator string, entry *streamkit.Entry) error {
+	switch p.unknownPolicy {
+	case UnknownDiscriminatorSkip:
+		if n := p.skipped.add(discriminator); n == 1 {
+			p.log().WarnContext(ctx, "skipping stream entries with unknown discriminator",
+				"discriminator", discriminator,
+				"space", entry.Space)
+		}
+		return nil
+	case UnknownDiscriminatorFallback:
+		if p.unknownHandler != nil {
+			return p.unknownHandler(ctx, discriminator, entry)
+		}
+	}
+	return fmt.Errorf("no handler registered for discriminator %q", discriminator)
+}
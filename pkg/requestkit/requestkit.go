@@ -0,0 +1,124 @@
+// Package requestkit sends a single request over a message bus and turns its
+// reply into a typed response or an error. It holds the request handling
+// shared by messaging.RequestClient and the lease client, which cannot
+// import the messaging package.
+package requestkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fgrzl/messaging"
+	"github.com/hydn-co/mesh-sdk/pkg/breaker"
+	"github.com/hydn-co/mesh-sdk/pkg/tracing"
+	"github.com/nats-io/nats.go"
+)
+
+// ErrTimeout is wrapped by errors returned when a request receives no reply
+// within its timeout.
+var ErrTimeout = errors.New("request timed out")
+
+// ErrForbidden is wrapped by errors returned when the request handler denied
+// the caller. Handlers deny by failing with an error wrapping it, which
+// reaches the client as a messaging.ErrorResponse starting with "forbidden".
+var ErrForbidden = errors.New("forbidden")
+
+// RemoteError is a failure reported by a request handler through a
+// messaging.ErrorResponse.
+type RemoteError struct {
+	Discriminator string
+	Message       string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("request %q failed: %s", e.Discriminator, e.Message)
+}
+
+// ErrorDecoder converts a response that reports a failure into an error. It
+// returns nil for responses it does not recognize.
+type ErrorDecoder func(resp messaging.Response) error
+
+// Options configure Send.
+type Options struct {
+	// Timeout is how long to wait for the reply.
+	Timeout time.Duration
+	// Breaker, if set, fails the request fast while open. Transport failures
+	// and timeouts count against it; error responses do not, and neither do
+	// requests abandoned because the caller's context is done.
+	Breaker *breaker.Breaker
+	// Decoders run, in order, before the built-in decoding of
+	// messaging.ErrorResponse.
+	Decoders []ErrorDecoder
+}
+
+// Send sends req on bus within a client span and returns its TResp reply.
+// Error responses are returned as errors, denials as errors wrapping
+// ErrForbidden, and a reply that does not arrive within the timeout as an
+// error wrapping ErrTimeout.
+func Send[TResp messaging.Response](ctx context.Context, bus messaging.MessageBus, req messaging.Request, o Options) (TResp, error) {
+	var zero TResp
+	discriminator := req.GetDiscriminator()
+	if o.Breaker != nil {
+		if err := o.Breaker.Allow(); err != nil {
+			return zero, fmt.Errorf("request %q: %w", discriminator, err)
+		}
+	}
+
+	ctx, span := tracing.StartSend(ctx, req.GetRoute().String(), discriminator)
+	resp, err := bus.RequestWithContext(ctx, req, o.Timeout)
+	// a caller that gave up says nothing about the service
+	abandoned := err != nil && ctx.Err() != nil
+	if o.Breaker != nil {
+		if abandoned {
+			o.Breaker.Release()
+		} else {
+			o.Breaker.Record(err)
+		}
+	}
+	if err == nil {
+		err = decodeError(discriminator, resp, o.Decoders)
+	} else if !abandoned && isTimeout(err) {
+		err = fmt.Errorf("%w: %q after %s: %w", ErrTimeout, discriminator, o.Timeout, err)
+	}
+	if err != nil {
+		tracing.End(span, err)
+		return zero, err
+	}
+
+	typed, ok := resp.(TResp)
+	if !ok {
+		err = fmt.Errorf("unexpected response type for %q: %T", discriminator, resp)
+	}
+	tracing.End(span, err)
+	return typed, err
+}
+
+func decodeError(discriminator string, resp messaging.Response, decoders []ErrorDecoder) error {
+	for _, decode := range decoders {
+		if err := decode(resp); err != nil {
+			return err
+		}
+	}
+	if r, ok := resp.(*messaging.ErrorResponse); ok {
+		if reason, ok := strings.CutPrefix(r.Error, ErrForbidden.Error()); ok {
+			if reason == "" {
+				return ErrForbidden
+			}
+			if reason, ok = strings.CutPrefix(reason, ": "); ok {
+				return fmt.Errorf("%w: %s", ErrForbidden, reason)
+			}
+		}
+		return &RemoteError{Discriminator: discriminator, Message: r.Error}
+	}
+	return nil
+}
+
+// isTimeout reports whether err means the reply did not arrive within the
+// request timeout, as reported by NATS or by an in-process bus honoring a
+// deadline of its own. Callers must rule out the caller's context first.
+func isTimeout(err error) bool {
+	return errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,66 @@
+package requestkit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fgrzl/messaging"
+	"github.com/hydn-co/mesh-sdk/pkg/testkit"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type ping struct{}
+
+func (p *ping) GetDiscriminator() string { return "mesh://test/ping" }
+
+func (p *ping) GetRoute() messaging.Route {
+	return messaging.NewInternalRoute("test", "ping")
+}
+
+func TestShouldReturnErrorResponseAsRemoteError(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	bus.Mock.On("RequestWithContext", mock.Anything, mock.Anything, time.Second).
+		Return(&messaging.ErrorResponse{Error: "boom"}, nil).Once()
+
+	// Act
+	_, err := Send[*messaging.Accepted](context.Background(), bus, &ping{}, Options{Timeout: time.Second})
+
+	// Assert
+	var remote *RemoteError
+	require.ErrorAs(t, err, &remote)
+	assert.Equal(t, "boom", remote.Message)
+	assert.Equal(t, "mesh://test/ping", remote.Discriminator)
+}
+
+func TestShouldReturnForbiddenErrorResponseAsErrForbidden(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	bus.Mock.On("RequestWithContext", mock.Anything, mock.Anything, time.Second).
+		Return(&messaging.ErrorResponse{Error: "forbidden: missing role \"admin\""}, nil).Once()
+
+	// Act
+	_, err := Send[*messaging.Accepted](context.Background(), bus, &ping{}, Options{Timeout: time.Second})
+
+	// Assert
+	require.ErrorIs(t, err, ErrForbidden)
+	assert.Equal(t, "forbidden: missing role \"admin\"", err.Error())
+}
+
+func TestShouldWrapTimeouts(t *testing.T) {
+	// Arrange
+	bus := testkit.NewMockMessageBus()
+	bus.Mock.On("RequestWithContext", mock.Anything, mock.Anything, time.Second).
+		Return(&messaging.Accepted{}, nats.ErrTimeout).Once()
+
+	// Act
+	_, err := Send[*messaging.Accepted](context.Background(), bus, &ping{}, Options{Timeout: time.Second})
+
+	// Assert
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, nats.ErrTimeout)
+}
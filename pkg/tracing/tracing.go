@@ -0,0 +1,101 @@
+// Package tracing propagates W3C trace context across the message bus and
+// stream entries so a request can be followed across services. Bus messages
+// carry it in traceparent and tracestate headers and stream records in
+// metadata entries of the same names.
+package tracing
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/hydn-co/mesh-sdk/pkg/tracing"
+
+// Header and metadata keys written by InjectHeader and InjectMetadata.
+const (
+	TraceParentKey = "traceparent"
+	TraceStateKey  = "tracestate"
+)
+
+var traceContext = propagation.TraceContext{}
+
+// Tracer returns the tracer used for bus and stream spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// InjectHeader writes the span context of ctx into the headers of a NATS
+// message as W3C traceparent and tracestate headers.
+func InjectHeader(ctx context.Context, h nats.Header) {
+	traceContext.Inject(ctx, headerCarrier(h))
+}
+
+// ExtractHeader returns ctx with the remote span context in the headers of a
+// NATS message, if any.
+func ExtractHeader(ctx context.Context, h nats.Header) context.Context {
+	if h == nil {
+		return ctx
+	}
+	return traceContext.Extract(ctx, headerCarrier(h))
+}
+
+// headerCarrier adapts nats.Header, whose keys are case sensitive, to the
+// propagation API.
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c headerCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InjectMetadata writes the span context of ctx into md as W3C traceparent
+// and tracestate entries.
+func InjectMetadata(ctx context.Context, md map[string]string) {
+	traceContext.Inject(ctx, propagation.MapCarrier(md))
+}
+
+// ExtractMetadata returns ctx with the remote span context in md, if any.
+func ExtractMetadata(ctx context.Context, md map[string]string) context.Context {
+	if md == nil {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.MapCarrier(md))
+}
+
+// StartSend starts a client span for sending a message with discriminator to
+// route. Buses that propagate trace context send it with the message. End it
+// with End.
+func StartSend(ctx context.Context, route, discriminator string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "send "+route,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("messaging.route", route),
+			attribute.String("messaging.discriminator", discriminator),
+		))
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newSpanContext() trace.SpanContext {
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	})
+}
+
+func TestShouldRoundTripSpanContextThroughHeaders(t *testing.T) {
+	// Arrange
+	sc := newSpanContext()
+	h := nats.Header{}
+	InjectHeader(trace.ContextWithSpanContext(context.Background(), sc), h)
+
+	// Act
+	ctx := ExtractHeader(context.Background(), h)
+
+	// Assert
+	assert.NotEmpty(t, h.Get(TraceParentKey))
+	got := trace.SpanContextFromContext(ctx)
+	assert.Equal(t, sc.TraceID(), got.TraceID())
+	assert.Equal(t, sc.SpanID(), got.SpanID())
+	assert.True(t, got.IsSampled())
+	assert.True(t, got.IsRemote())
+}
+
+func TestShouldIgnoreHeadersWithoutTraceContext(t *testing.T) {
+	// Arrange
+	h := nats.Header{}
+	h.Set("X-Correlation-ID", "f47ac10b-58cc-4372-a567-0e02b2c3d479")
+
+	// Act
+	ctx := ExtractHeader(context.Background(), h)
+
+	// Assert
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
+
+func TestShouldRoundTripSpanContextThroughMetadata(t *testing.T) {
+	// Arrange
+	sc := newSpanContext()
+	md := map[string]string{}
+	InjectMetadata(trace.ContextWithSpanContext(context.Background(), sc), md)
+
+	// Act
+	ctx := ExtractMetadata(context.Background(), md)
+
+	// Assert
+	assert.NotEmpty(t, md[TraceParentKey])
+	assert.Equal(t, sc.TraceID(), trace.SpanContextFromContext(ctx).TraceID())
+}